@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jing2uo/tdx2db/database"
+	"github.com/jing2uo/tdx2db/model"
+	"github.com/jing2uo/tdx2db/tdx"
+)
+
+type BackfillKind string
+
+const (
+	BackfillDay  BackfillKind = "day"
+	Backfill1Min BackfillKind = "1min"
+	Backfill5Min BackfillKind = "5min"
+)
+
+var backfillKindCN = map[BackfillKind]string{
+	BackfillDay:  "日线",
+	Backfill1Min: "1分钟",
+	Backfill5Min: "5分钟",
+}
+
+type BackfillOptions struct {
+	DBPath string
+	Kind   BackfillKind
+	From   time.Time
+	To     time.Time
+	// Universe 为导入范围名称，为空时使用 init 保存在数据库中的范围
+	Universe []string
+	// Source 为空时使用默认镜像从通达信官网下载
+	Source DataSource
+}
+
+// Backfill 下载 [From, To] 范围内的每日数据并写入数据库，只替换该范围内的记录
+func Backfill(opts BackfillOptions) error {
+	if opts.DBPath == "" {
+		return fmt.Errorf("database path cannot be empty")
+	}
+	if opts.From.After(opts.To) {
+		return fmt.Errorf("--from %s is after --to %s", opts.From.Format("2006-01-02"), opts.To.Format("2006-01-02"))
+	}
+	if opts.To.After(Today) {
+		return fmt.Errorf("--to %s is after today %s", opts.To.Format("2006-01-02"), Today.Format("2006-01-02"))
+	}
+
+	var dataType DataKind
+	switch opts.Kind {
+	case BackfillDay:
+		dataType = DayKind
+	case Backfill1Min, Backfill5Min:
+		dataType = TicKind
+	default:
+		return fmt.Errorf("unknown backfill kind: %s", opts.Kind)
+	}
+
+	source := opts.Source
+	if source == nil {
+		source = NewHTTPSource(nil, nil)
+	}
+
+	dbConfig := model.DBConfig{Path: opts.DBPath}
+	db, err := database.Connect(dbConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	fmt.Printf("📅 回补 %s ~ %s 的%s数据\n", opts.From.Format("2006-01-02"), opts.To.Format("2006-01-02"), backfillKindCN[opts.Kind])
+
+	prefixes, err := resolveUniverse(db, opts.Universe, false)
+	if err != nil {
+		return fmt.Errorf("failed to resolve universe: %w", err)
+	}
+
+	tradeDays, err := backfillTradeDays(opts.From, opts.To)
+	if err != nil {
+		return err
+	}
+
+	outcomes, err := prepareTdxData(source, tradeDays, dataType)
+	printFetchReport(outcomes)
+	if err != nil {
+		return fmt.Errorf("failed to prepare tdx data: %w", err)
+	}
+
+	if len(downloadedDates(outcomes)) > 0 {
+		if err := importBackfill(db, opts, prefixes); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("🌲 没有可回补的数据")
+	}
+
+	if failed := failedOutcomes(outcomes); len(failed) > 0 {
+		first := failed[0]
+		return fmt.Errorf("%d dates failed, first: %s %s (%s)", len(failed), first.Kind, first.Date.Format("2006-01-02"), first.Reason)
+	}
+
+	fmt.Println("🚀 回补完成")
+	return nil
+}
+
+// backfillTradeDays 按休市安排推算范围内的交易日。已有数据中的缺口在交易日历里记为休市，
+// 所以这里不使用交易日历。
+func backfillTradeDays(from, to time.Time) ([]database.TradeDay, error) {
+	rule, err := tradeDayRule()
+	if err != nil {
+		return nil, err
+	}
+
+	var days []database.TradeDay
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if open, source := rule(d); open {
+			days = append(days, database.TradeDay{Date: d, IsOpen: true, Source: source})
+		}
+	}
+	return days, nil
+}
+
+func importBackfill(db *sql.DB, opts BackfillOptions, prefixes []string) error {
+	filter := tdx.Filter{Prefixes: prefixes, From: opts.From, To: opts.To}
+	switch opts.Kind {
+	case BackfillDay:
+		fmt.Println("🐢 开始导入日线数据")
+		source := fileSource(VipdocDir, filter, ".day")
+		if err := database.ImportStocks(db, source); err != nil {
+			return fmt.Errorf("failed to import day files: %w", err)
+		}
+		fmt.Println("📊 日线数据导入成功")
+
+		if err := refreshTradeCalendar(db); err != nil {
+			return err
+		}
+		if err := refreshSymbols(db); err != nil {
+			return err
+		}
+		if err := backfillFactors(db, opts.From, opts.To); err != nil {
+			return err
+		}
+		return backfillTurnover(db, opts.From, opts.To)
+
+	case Backfill1Min:
+		source := fileSource(VipdocDir, filter, ".01")
+		if err := database.Import1MinLine(db, source); err != nil {
+			return fmt.Errorf("failed to import .01 files: %w", err)
+		}
+		fmt.Println("📊 1分钟数据导入成功")
+
+	case Backfill5Min:
+		source := fileSource(VipdocDir, filter, ".5")
+		if err := database.Import5MinLine(db, source); err != nil {
+			return fmt.Errorf("failed to import .5 files: %w", err)
+		}
+		fmt.Println("📊 5分钟数据导入成功")
+	}
+	return nil
+}
+
+// backfillTurnover 删除回补范围内已计算的换手率后增量更新。没有股本结构表时跳过，
+// 有股本结构表但还没有换手率表时由 UpdateTurnover 全量计算。
+func backfillTurnover(db *sql.DB, from, to time.Time) error {
+	if err := database.InvalidateTurnover(db, from, to); err != nil {
+		return err
+	}
+	changes, err := database.UpdateTurnover(db)
+	if err != nil {
+		return fmt.Errorf("failed to update turnover: %w", err)
+	}
+	if changes.Written > 0 {
+		fmt.Printf("🔄 换手率更新成功: 写入 %d 行\n", changes.Written)
+	}
+	return nil
+}
+
+// backfillFactors 重新计算回补范围内有数据的股票的复权因子，尚未计算过因子时交给 cron 处理
+func backfillFactors(db *sql.DB, from, to time.Time) error {
+	initialized, err := database.FactorsInitialized(db)
+	if err != nil {
+		return fmt.Errorf("failed to check factor tables: %w", err)
+	}
+	if !initialized {
+		return nil
+	}
+
+	symbols, err := database.QuerySymbolsBetween(db, from, to)
+	if err != nil {
+		return err
+	}
+	if len(symbols) == 0 {
+		return nil
+	}
+	if err := recalculateFactors(db, symbols); err != nil {
+		return err
+	}
+	fmt.Println("🔢 复权因子更新成功")
+	return nil
+}
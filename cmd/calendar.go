@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jing2uo/tdx2db/database"
+	"github.com/jing2uo/tdx2db/tdx"
+)
+
+// tradeDayRule 按内置的上交所休市安排推算交易日，未收录的年份只排除周末
+func tradeDayRule() (database.TradeDayRule, error) {
+	holidays, err := tdx.LoadSSEHolidays()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SSE holidays: %w", err)
+	}
+	if !holidays.Covers(Today) {
+		fmt.Printf("⚠️ 内置休市安排未收录 %d 年，暂按周末推算交易日\n", Today.Year())
+	}
+
+	return func(date time.Time) (bool, string) {
+		if holidays.Covers(date) {
+			return holidays.IsTradingDay(date), database.CalendarSSE
+		}
+		return holidays.IsTradingDay(date), database.CalendarWeekday
+	}, nil
+}
+
+// refreshTradeCalendar 根据日线数据和内置的上交所休市安排刷新交易日历
+func refreshTradeCalendar(db *sql.DB) error {
+	rule, err := tradeDayRule()
+	if err != nil {
+		return err
+	}
+	if err := database.RefreshTradeCalendar(db, rule, Today); err != nil {
+		return fmt.Errorf("failed to refresh trade calendar: %w", err)
+	}
+	return nil
+}
+
+// expectedTradeDays 返回 latestDate 之后预期开市且应当已发布数据的日期，
+// 未到发布时间时不包含今天
+func expectedTradeDays(db *sql.DB, latestDate time.Time) ([]database.TradeDay, error) {
+	if err := refreshTradeCalendar(db); err != nil {
+		return nil, err
+	}
+	through := lastPublishedDate()
+	if through.Before(Today) {
+		fmt.Printf("🕔 未到当日数据发布时间 (%s)，不获取 %s 的数据\n", formatCutoff(PublishCutoff), Today.Format("2006-01-02"))
+	}
+	return database.QueryTradeDays(db, latestDate.AddDate(0, 0, 1), through)
+}
+
+func formatCutoff(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -1,36 +1,135 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/jing2uo/tdx2db/database"
+	"github.com/jing2uo/tdx2db/model"
+	"github.com/jing2uo/tdx2db/tdx"
 	"github.com/jing2uo/tdx2db/utils"
 )
 
 var maxConcurrency = runtime.NumCPU()
-var Today = time.Now().Truncate(24 * time.Hour)
+
+// Now 为判断交易日期的参考时间，默认是当前时间，可通过 --as-of 固定
+var Now = time.Now()
+
+// Today 为 Now 在交易所时区的日期
+var Today = tdx.MarketDate(Now)
+
+// PublishCutoff 为交易所时区当日数据预计发布完成的时间，在此之前不请求当天的数据
+var PublishCutoff = 17 * time.Hour
 
 var DataDir, _ = utils.GetCacheDir()
 var VipdocDir = filepath.Join(DataDir, "vipdoc")
-var StockCSV = filepath.Join(DataDir, "stock.csv")
-var OneMinLineCSV = filepath.Join(DataDir, "1min.csv")
-var FiveMinLineCSV = filepath.Join(DataDir, "5min.csv")
-
-var ValidPrefixes = []string{
-	"sz30",     // 创业板
-	"sz00",     // 深证主板
-	"sh60",     // 上证主板
-	"sh68",     // 科创板
-	"bj920",    // 北证
-	"sh000300", // 沪深300
-	"sh000905", // 中证500
-	"sh000852", // 中证1000
-	"sh000001", // 上证指数
-	"sz399001", // 深证指数
-	"sz399006", // 创业板指
-	"sh000680", // 科创综指
-	"bj899050", // 北证50
-	"sh880",    // 通达信概念、风格板块
-	"sh881",    // 通达信行业
+
+// fileSource 返回从目录中解析指定后缀文件的流式数据源
+func fileSource(dir string, filter tdx.Filter, suffix string) database.StockDataSource {
+	return func(emit func(model.StockData) error) error {
+		return tdx.ReadFiles(dir, filter, suffix, emit)
+	}
+}
+
+// NarrowPrefixes 返回同时满足 base 和 extra 的前缀，extra 为空时返回 base。
+// 例如 base 为 sh60 时，extra 为 sh 得到 sh60，extra 为 sh600 得到 sh600。
+func NarrowPrefixes(base, extra []string) []string {
+	if len(extra) == 0 {
+		return base
+	}
+	var result []string
+	for _, b := range base {
+		for _, e := range extra {
+			switch {
+			case strings.HasPrefix(b, e):
+				result = append(result, b)
+			case strings.HasPrefix(e, b):
+				result = append(result, e)
+			}
+		}
+	}
+	return result
+}
+
+// ParseSymbols 解析 --symbols 参数，每一项可以是代码或每行一个代码的文件，# 开头的行为注释
+func ParseSymbols(values []string) ([]string, error) {
+	var symbols []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if info, err := os.Stat(v); err == nil && !info.IsDir() {
+			data, err := os.ReadFile(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read symbols file %s: %w", v, err)
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				for _, symbol := range strings.Split(line, ",") {
+					if symbol = strings.TrimSpace(symbol); symbol != "" {
+						symbols = append(symbols, strings.ToLower(symbol))
+					}
+				}
+			}
+			continue
+		}
+		symbols = append(symbols, strings.ToLower(v))
+	}
+
+	for _, symbol := range symbols {
+		if !symbolPattern.MatchString(symbol) {
+			return nil, fmt.Errorf("invalid symbol %q, expected a code like sh600000 or a file", symbol)
+		}
+	}
+	return symbols, nil
+}
+
+var symbolPattern = regexp.MustCompile(`^(sh|sz|bj)[0-9a-z]+$`)
+
+// SetMarketClock 设置交易所时区和参考时间，并重新计算 Today
+func SetMarketClock(loc *time.Location, now time.Time) {
+	tdx.MarketLocation = loc
+	Now = now
+	Today = tdx.MarketDate(now)
+}
+
+// ParseAsOf 解析 --as-of 参数。只给出日期时视为当天收盘发布之后，
+// 也可以用 "2006-01-02 15:04" 指定交易所时区的具体时间。
+func ParseAsOf(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --as-of %q, expected YYYY-MM-DD or \"YYYY-MM-DD HH:MM\"", value)
+	}
+	return t.Add(24*time.Hour - time.Second), nil
+}
+
+// ParsePublishCutoff 解析 HH:MM 格式的发布时间
+func ParsePublishCutoff(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid publish time %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// lastPublishedDate 返回按发布时间应当已有数据的最后一个日期
+func lastPublishedDate() time.Time {
+	local := Now.In(tdx.MarketLocation)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, tdx.MarketLocation)
+	if local.Sub(midnight) < PublishCutoff {
+		return Today.AddDate(0, 0, -1)
+	}
+	return Today
 }
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/jing2uo/tdx2db/database"
 	"github.com/jing2uo/tdx2db/tdx"
 	"github.com/jing2uo/tdx2db/utils"
 )
@@ -16,6 +18,12 @@ type ConvertOptions struct {
 	InputPath  string
 	InputType  InputSourceType
 	OutputPath string
+	// Format 为输出格式 csv、parquet 或 jsonl，为空时输出 csv
+	Format string
+	// Universe 为转换范围名称，为空时转换全部代码
+	Universe []string
+	// Filter 限定转换的代码和日期，Prefixes 与转换范围取交集
+	Filter tdx.Filter
 }
 
 const (
@@ -44,6 +52,15 @@ func Convert(opts ConvertOptions) error {
 		return errors.New("output path cannot be empty")
 	}
 
+	format := opts.Format
+	switch format {
+	case "":
+		format = database.FormatCSV
+	case database.FormatCSV, database.FormatParquet, database.FormatJSONL:
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+
 	if err := utils.CheckOutputDir(opts.OutputPath); err != nil {
 		return err
 	}
@@ -60,16 +77,26 @@ func Convert(opts ConvertOptions) error {
 
 	dataDir := DataDir
 
-	var validPrefixes = []string{"sh", "sz", "bj"}
+	universe := opts.Universe
+	if len(universe) == 0 {
+		universe = []string{"all"}
+	}
+	prefixes, err := UniversePrefixes(universe)
+	if err != nil {
+		return err
+	}
+	filter := opts.Filter
+	filter.Prefixes = NarrowPrefixes(prefixes, opts.Filter.Prefixes)
+	if len(filter.Prefixes) == 0 {
+		return fmt.Errorf("prefixes %v are outside the universe %s", opts.Filter.Prefixes, strings.Join(universe, ","))
+	}
 
 	switch opts.InputType {
 
 	case DayFileDir:
 		fmt.Printf("📦 开始处理日线目录: %s\n", opts.InputPath)
-		output := filepath.Join(opts.OutputPath, "tdx2db_day.csv")
-
 		fmt.Println("🐢 开始转换日线数据")
-		_, err := tdx.ConvertFiles2Csv(opts.InputPath, validPrefixes, output, ".day")
+		output, err := convertStockFiles(opts.InputPath, filter, ".day", filepath.Join(opts.OutputPath, "tdx2db_day"), format)
 		if err != nil {
 			return fmt.Errorf("failed to convert day files: %w", err)
 		}
@@ -78,10 +105,8 @@ func Convert(opts ConvertOptions) error {
 
 	case Min1FileDir:
 		fmt.Printf("📦 开始处理分时数据目录: %s\n", opts.InputPath)
-		output := filepath.Join(opts.OutputPath, "tdx2db_1min.csv")
-
 		fmt.Println("🐢 开始转换 1 分钟数据")
-		_, err := tdx.ConvertFiles2Csv(opts.InputPath, validPrefixes, output, ".01")
+		output, err := convertStockFiles(opts.InputPath, filter, ".01", filepath.Join(opts.OutputPath, "tdx2db_1min"), format)
 		if err != nil {
 			return fmt.Errorf("failed to convert 1min files: %w", err)
 		}
@@ -90,10 +115,8 @@ func Convert(opts ConvertOptions) error {
 
 	case Min5FileDir:
 		fmt.Printf("📦 开始处理分时数据目录: %s\n", opts.InputPath)
-		output := filepath.Join(opts.OutputPath, "tdx2db_5min.csv")
-
 		fmt.Println("🐢 开始转换 5 分钟数据")
-		_, err := tdx.ConvertFiles2Csv(opts.InputPath, validPrefixes, output, ".5")
+		output, err := convertStockFiles(opts.InputPath, filter, ".5", filepath.Join(opts.OutputPath, "tdx2db_5min"), format)
 		if err != nil {
 			return fmt.Errorf("failed to convert 5min files: %w", err)
 		}
@@ -122,17 +145,14 @@ func Convert(opts ConvertOptions) error {
 			return fmt.Errorf("failed to execute DatatoolMinCreate: %w", err)
 		}
 
-		min1_output := filepath.Join(opts.OutputPath, fmt.Sprintf("%s_1min.csv", baseName))
-		min5_output := filepath.Join(opts.OutputPath, fmt.Sprintf("%s_5min.csv", baseName))
-
 		fmt.Printf("🐢 开始转换 1 分钟数据\n")
-		_, err := tdx.ConvertFiles2Csv(VipdocDir, validPrefixes, min1_output, ".01")
+		min1_output, err := convertStockFiles(VipdocDir, filter, ".01", filepath.Join(opts.OutputPath, baseName+"_1min"), format)
 		if err != nil {
 			return fmt.Errorf("failed to convert 1-minute files: %w", err)
 		}
 
 		fmt.Printf("🐢 开始转换 5 分钟数据\n")
-		_, err = tdx.ConvertFiles2Csv(VipdocDir, validPrefixes, min5_output, ".5")
+		min5_output, err := convertStockFiles(VipdocDir, filter, ".5", filepath.Join(opts.OutputPath, baseName+"_5min"), format)
 		if err != nil {
 			return fmt.Errorf("failed to convert 5-minute files: %w", err)
 		}
@@ -160,9 +180,7 @@ func Convert(opts ConvertOptions) error {
 			return fmt.Errorf("failed to execute DatatoolDayCreate: %w", err)
 		}
 
-		output := filepath.Join(opts.OutputPath, fmt.Sprintf("%s_day.csv", baseName))
-
-		_, err := tdx.ConvertFiles2Csv(VipdocDir, validPrefixes, output, ".day")
+		output, err := convertStockFiles(VipdocDir, filter, ".day", filepath.Join(opts.OutputPath, baseName+"_day"), format)
 		if err != nil {
 			return fmt.Errorf("failed to convert day files: %w", err)
 		}
@@ -184,9 +202,9 @@ func Convert(opts ConvertOptions) error {
 		}
 
 		gbbq := filepath.Join(unzipDestPath, "gbbq")
-		output := filepath.Join(opts.OutputPath, "tdx2db_gbbq.csv")
+		output := filepath.Join(opts.OutputPath, "tdx2db_gbbq."+format)
 		fmt.Printf("🐢 开始转换股本变迁数据\n")
-		_, err := tdx.ConvertGbbqFile2Csv(gbbq, output)
+		err := convertGbbqFile(gbbq, output, format)
 		if err != nil {
 			return fmt.Errorf("failed to convert gbbq file: %w", err)
 		}
@@ -195,3 +213,34 @@ func Convert(opts ConvertOptions) error {
 
 	return nil
 }
+
+// convertStockFiles 将目录中指定后缀的文件转换为 format 格式，输出路径为 output 加上格式扩展名
+func convertStockFiles(dir string, filter tdx.Filter, suffix, output, format string) (string, error) {
+	output += "." + format
+	if format == database.FormatCSV {
+		return tdx.ConvertFiles2Csv(dir, filter, output, suffix)
+	}
+
+	schema := database.StocksSchema
+	if suffix != ".day" {
+		schema = database.OneMinLineSchema
+	}
+	scratch := filepath.Join(DataDir, "convert.duckdb")
+	if err := database.WriteStockFile(scratch, schema, fileSource(dir, filter, suffix), output, format); err != nil {
+		return output, err
+	}
+	return output, nil
+}
+
+func convertGbbqFile(gbbqFile, output, format string) error {
+	if format == database.FormatCSV {
+		_, err := tdx.ConvertGbbqFile2Csv(gbbqFile, output)
+		return err
+	}
+
+	data, err := tdx.ReadGbbqFile(gbbqFile)
+	if err != nil {
+		return err
+	}
+	return database.WriteGbbqFile(filepath.Join(DataDir, "convert.duckdb"), data, output, format)
+}
@@ -7,7 +7,6 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/jing2uo/tdx2db/database"
 	"github.com/jing2uo/tdx2db/model"
@@ -17,35 +16,85 @@ import (
 
 type XdxrIndex map[string][]model.XdxrData
 
-func Cron(dbPath string, minline string) error {
+type CronOptions struct {
+	DBPath  string
+	Minline string
+	// Universe 为导入范围名称，为空时使用 init 保存在数据库中的范围
+	Universe []string
+	// Source 为空时使用默认镜像从通达信官网下载
+	Source DataSource
+}
+
+func Cron(opts CronOptions) error {
 
-	if dbPath == "" {
+	if opts.DBPath == "" {
 		return fmt.Errorf("database path cannot be empty")
 	}
-	dbConfig := model.DBConfig{Path: dbPath}
+	source := opts.Source
+	if source == nil {
+		source = NewHTTPSource(nil, nil)
+	}
+
+	dbConfig := model.DBConfig{Path: opts.DBPath}
 	db, err := database.Connect(dbConfig)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 	defer db.Close()
 
+	var outcomes []DateOutcome
+	err = runCron(db, source, opts, &outcomes)
+	printFetchReport(outcomes)
+	if err != nil {
+		return err
+	}
+
+	if failed := failedOutcomes(outcomes); len(failed) > 0 {
+		first := failed[0]
+		return fmt.Errorf("%d dates failed, first: %s %s (%s)", len(failed), first.Kind, first.Date.Format("2006-01-02"), first.Reason)
+	}
+
+	fmt.Println("🚀 今日任务执行成功")
+	return nil
+}
+
+// runCron 执行更新流程，outcomes 记录每个日期的数据获取结果，出错时也会保留已有结果
+func runCron(db *sql.DB, source DataSource, opts CronOptions, outcomes *[]DateOutcome) error {
 	latestStockDate, err := database.GetStockTableLatestDate(db)
 	if err != nil {
 		return fmt.Errorf("failed to get latest date from database: %w", err)
 	}
 	fmt.Printf("📅 日线数据的最新日期为 %s\n", latestStockDate.Format("2006-01-02"))
 
-	err = UpdateStocksDaily(db, latestStockDate)
+	prefixes, err := resolveUniverse(db, opts.Universe, false)
+	if err != nil {
+		return fmt.Errorf("failed to resolve universe: %w", err)
+	}
+
+	tradeDays, err := expectedTradeDays(db, latestStockDate)
+	if err != nil {
+		return fmt.Errorf("failed to get expected trading days: %w", err)
+	}
+
+	dayOutcomes, err := UpdateStocksDaily(db, source, tradeDays, prefixes)
+	*outcomes = append(*outcomes, dayOutcomes...)
 	if err != nil {
 		return fmt.Errorf("failed to update daily stock data: %w", err)
 	}
 
-	err = UpdateStocksMinLine(db, latestStockDate, minline)
+	// 每次都刷新，已有数据库升级后也能生成代码维表
+	fmt.Printf("🔄 更新代码维表 (%s)\n", database.SymbolsSchema.Name)
+	if err := refreshSymbols(db); err != nil {
+		return err
+	}
+
+	minOutcomes, err := UpdateStocksMinLine(db, source, tradeDays, opts.Minline, prefixes)
+	*outcomes = append(*outcomes, minOutcomes...)
 	if err != nil {
 		return fmt.Errorf("failed to update minute-line stock data: %w", err)
 	}
 
-	err = UpdateGbbq(db)
+	err = UpdateGbbq(db, source)
 	if err != nil {
 		return fmt.Errorf("failed to update GBBQ: %w", err)
 	}
@@ -70,113 +119,159 @@ func Cron(dbPath string, minline string) error {
 		return fmt.Errorf("failed to create hfq view: %w", err)
 	}
 
-	fmt.Println("🚀 今日任务执行成功")
+	fmt.Printf("🔄 更新按基准日前复权表宏 (%s)\n", database.QfqAsOfMacroName)
+	if err := database.CreateQfqAsOfMacro(db); err != nil {
+		return fmt.Errorf("failed to create qfq as-of macro: %w", err)
+	}
+
+	// 分钟表可能由 backfill 导入，不论本次是否指定 --minline 都更新视图
+	if err := database.CreateMinLineFqViews(db); err != nil {
+		return fmt.Errorf("failed to create minute-line fq views: %w", err)
+	}
+
 	return nil
 }
 
-func UpdateStocksDaily(db *sql.DB, latestDate time.Time) error {
-	validDates, err := prepareTdxData(latestDate, "day")
+func UpdateStocksDaily(db *sql.DB, source DataSource, tradeDays []database.TradeDay, prefixes []string) ([]DateOutcome, error) {
+	outcomes, err := prepareTdxData(source, tradeDays, DayKind)
 	if err != nil {
-		return fmt.Errorf("failed to prepare tdx data: %w", err)
+		return outcomes, fmt.Errorf("failed to prepare tdx data: %w", err)
 	}
-	if len(validDates) > 0 {
-		fmt.Printf("🐢 开始转换日线数据\n")
-		_, err := tdx.ConvertFiles2Csv(VipdocDir, ValidPrefixes, StockCSV, ".day")
-		if err != nil {
-			return fmt.Errorf("failed to convert day files to CSV: %w", err)
-		}
-		if err := database.ImportStockCsv(db, StockCSV); err != nil {
-			return fmt.Errorf("failed to import stock CSV: %w", err)
+	if len(downloadedDates(outcomes)) > 0 {
+		fmt.Printf("🐢 开始导入日线数据\n")
+		if err := database.ImportStocks(db, fileSource(VipdocDir, tdx.Filter{Prefixes: prefixes}, ".day")); err != nil {
+			return outcomes, fmt.Errorf("failed to import day files: %w", err)
 		}
 		fmt.Println("📊 日线数据导入成功")
+		if err := refreshTradeCalendar(db); err != nil {
+			return outcomes, err
+		}
+	} else if len(failedOutcomes(outcomes)) > 0 {
+		fmt.Println("🛑 日线数据获取失败，未导入")
 	} else {
 		fmt.Println("🌲 日线数据无需更新")
 
 	}
-	return nil
+	return outcomes, nil
 }
 
-func UpdateStocksMinLine(db *sql.DB, latestDate time.Time, minline string) error {
+func UpdateStocksMinLine(db *sql.DB, source DataSource, tradeDays []database.TradeDay, minline string, prefixes []string) ([]DateOutcome, error) {
 	if minline == "" {
-		return nil
+		return nil, nil
 	}
 
-	validDates, err := prepareTdxData(latestDate, "tic")
+	outcomes, err := prepareTdxData(source, tradeDays, TicKind)
 	if err != nil {
-		return fmt.Errorf("failed to prepare tdx data: %w", err)
+		return outcomes, fmt.Errorf("failed to prepare tdx data: %w", err)
 	}
-	if len(validDates) > 0 {
+	if len(downloadedDates(outcomes)) > 0 {
 		parts := strings.Split(minline, ",")
 		for _, p := range parts {
 			switch p {
 			case "1":
-				_, err := tdx.ConvertFiles2Csv(VipdocDir, ValidPrefixes, OneMinLineCSV, ".01")
-				if err != nil {
-					return fmt.Errorf("failed to convert .01 files to CSV: %w", err)
-				}
-				if err := database.Import1MinLineCsv(db, OneMinLineCSV); err != nil {
-					return fmt.Errorf("failed to import 1-minute line CSV: %w", err)
+				if err := database.Import1MinLine(db, fileSource(VipdocDir, tdx.Filter{Prefixes: prefixes}, ".01")); err != nil {
+					return outcomes, fmt.Errorf("failed to import .01 files: %w", err)
 				}
 				fmt.Println("📊 1分钟数据导入成功")
 
 			case "5":
-				_, err := tdx.ConvertFiles2Csv(VipdocDir, ValidPrefixes, FiveMinLineCSV, ".5")
-				if err != nil {
-					return fmt.Errorf("failed to convert .5 files to CSV: %w", err)
-				}
-				if err := database.Import5MinLineCsv(db, FiveMinLineCSV); err != nil {
-					return fmt.Errorf("failed to import 5-minute line CSV: %w", err)
+				if err := database.Import5MinLine(db, fileSource(VipdocDir, tdx.Filter{Prefixes: prefixes}, ".5")); err != nil {
+					return outcomes, fmt.Errorf("failed to import .5 files: %w", err)
 				}
 				fmt.Println("📊 5分钟数据导入成功")
 			}
 		}
 
+	} else if len(failedOutcomes(outcomes)) > 0 {
+		fmt.Println("🛑 分时数据获取失败，未导入")
 	} else {
 		fmt.Println("🌲 分时数据无需更新")
 
 	}
-	return nil
+	return outcomes, nil
 }
 
-func UpdateGbbq(db *sql.DB) error {
-	fmt.Println("🐢 开始下载股本变迁数据")
+func UpdateGbbq(db *sql.DB, source DataSource) error {
+	fmt.Println("🐢 开始获取股本变迁数据")
 
-	gbbqFile, err := getGbbqFile(DataDir)
+	gbbqFile, err := getGbbqFile(source, DataDir)
 	if err != nil {
-		return fmt.Errorf("failed to download GBBQ file: %w", err)
+		return fmt.Errorf("failed to get GBBQ file: %w", err)
+	}
+	gbbqData, err := tdx.ReadGbbqFile(gbbqFile)
+	if err != nil {
+		return fmt.Errorf("failed to read GBBQ file: %w", err)
+	}
+
+	if err := database.ImportGbbq(db, gbbqData, tdx.CapitalHistory); err != nil {
+		return fmt.Errorf("failed to import GBBQ into database: %w", err)
 	}
-	gbbqCSV := filepath.Join(DataDir, "gbbq.csv")
-	if _, err := tdx.ConvertGbbqFile2Csv(gbbqFile, gbbqCSV); err != nil {
-		return fmt.Errorf("failed to convert GBBQ to CSV: %w", err)
+
+	fmt.Printf("🔄 更新股本变迁数据视图 (%s, %s 等)\n", database.XdxrViewName, database.ShareChangeViewName)
+	if err := createGbbqViews(db); err != nil {
+		return err
 	}
 
-	if err := database.ImportGbbqCsv(db, gbbqCSV); err != nil {
-		return fmt.Errorf("failed to import GBBQ CSV into database: %w", err)
+	if err := updateTurnover(db); err != nil {
+		return err
 	}
 
-	fmt.Printf("🔄 更新除权除息数据视图 (%s)\n", database.XdxrViewName)
-	if err := database.CreateXdxrView(db); err != nil {
-		return fmt.Errorf("failed to create xdxr view: %w", err)
+	fmt.Println("📈 股本变迁数据导入成功")
+	return nil
+}
+
+// updateTurnover 增量计算换手率和市值并更新视图
+func updateTurnover(db *sql.DB) error {
+	changes, err := database.UpdateTurnover(db)
+	if err != nil {
+		return fmt.Errorf("failed to update turnover: %w", err)
 	}
+	fmt.Printf("🔄 更新市值换手数据 (%s): 写入 %d 行，股本变化重算 %d 个代码\n", database.TurnoverSchema.Name, changes.Written, changes.Recomputed)
 
-	fmt.Printf("🔄 更新市值换手数据视图 (%s)\n", database.TurnoverViewName)
 	if err := database.CreateTurnoverView(db); err != nil {
 		return fmt.Errorf("failed to create turnover view: %w", err)
 	}
+	return nil
+}
 
-	fmt.Println("📈 股本变迁数据导入成功")
+// createGbbqViews 按 tdx.CategoryDetail 中的字段含义生成股本变迁视图
+func createGbbqViews(db *sql.DB) error {
+	categories, err := tdx.GbbqCategories()
+	if err != nil {
+		return err
+	}
+	if err := database.CreateGbbqViews(db, categories); err != nil {
+		return fmt.Errorf("failed to create gbbq views: %w", err)
+	}
 	return nil
 }
 
+// UpdateFactors 更新复权因子。已有因子时只为除权除息记录变化的股票全量重算，
+// 其余股票只追加新日期的因子。
 func UpdateFactors(db *sql.DB) error {
-	csvPath := filepath.Join(DataDir, "factors.csv")
+	initialized, err := database.FactorsInitialized(db)
+	if err != nil {
+		return fmt.Errorf("failed to check factor tables: %w", err)
+	}
 
-	outFile, err := os.Create(csvPath)
+	if initialized {
+		err = updateFactorsIncremental(db)
+	} else {
+		err = updateFactorsFull(db)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create CSV file %s: %w", csvPath, err)
+		return err
 	}
-	defer outFile.Close()
 
+	if err := database.SaveXdxrSnapshot(db); err != nil {
+		return fmt.Errorf("failed to save xdxr snapshot: %w", err)
+	}
+	fmt.Println("🔢 复权因子导入成功")
+
+	return nil
+}
+
+func updateFactorsFull(db *sql.DB) error {
 	fmt.Println("📟 计算所有股票前收盘价")
 	// 构建 GBBQ 索引
 	xdxrIndex, err := buildXdxrIndex(db)
@@ -190,88 +285,116 @@ func UpdateFactors(db *sql.DB) error {
 		return fmt.Errorf("failed to query all stock symbols: %w", err)
 	}
 
+	source := func(emit func(model.Factor) error) error {
+		return calculateFactors(db, symbols, xdxrIndex, emit)
+	}
+	if err := database.ImportFactors(db, source); err != nil {
+		return fmt.Errorf("failed to import factor data: %w", err)
+	}
+	return nil
+}
+
+func updateFactorsIncremental(db *sql.DB) error {
+	staleSymbols, err := database.QueryStaleFactorSymbols(db)
+	if err != nil {
+		return fmt.Errorf("failed to query stale factor symbols: %w", err)
+	}
+
+	if len(staleSymbols) > 0 {
+		if err := recalculateFactors(db, staleSymbols); err != nil {
+			return err
+		}
+	}
+
+	n, err := database.ExtendFactors(db)
+	if err != nil {
+		return fmt.Errorf("failed to extend factor data: %w", err)
+	}
+	fmt.Printf("📟 追加 %d 条复权因子\n", n)
+	return nil
+}
+
+// recalculateFactors 重新计算 symbols 的全部复权因子
+func recalculateFactors(db *sql.DB, symbols []string) error {
+	fmt.Printf("📟 重新计算 %d 只股票的前收盘价\n", len(symbols))
+	xdxrIndex, err := buildXdxrIndex(db)
+	if err != nil {
+		return fmt.Errorf("failed to build GBBQ index: %w", err)
+	}
+
+	source := func(emit func(model.Factor) error) error {
+		return calculateFactors(db, symbols, xdxrIndex, emit)
+	}
+	if err := database.ReplaceFactors(db, symbols, source); err != nil {
+		return fmt.Errorf("failed to replace factor data: %w", err)
+	}
+	return nil
+}
+
+// calculateFactors 并发计算各股票的复权因子，并在当前协程中逐条交给 emit
+func calculateFactors(db *sql.DB, symbols []string, xdxrIndex XdxrIndex, emit func(model.Factor) error) error {
 	// 定义结果通道
 	type result struct {
-		rows string
-		err  error
+		factors []model.Factor
+		err     error
 	}
 	results := make(chan result, len(symbols))
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, maxConcurrency)
 
-	// 启动写入协程
-	var writerWg sync.WaitGroup
-	writerWg.Add(1)
-	go func() {
-		defer writerWg.Done()
-		for res := range results {
-			if res.err != nil {
-				fmt.Printf("错误：%v\n", res.err)
-				continue
-			}
-			if _, err := outFile.WriteString(res.rows); err != nil {
-				fmt.Printf("写入 CSV 失败：%v\n", err)
-			}
-		}
-	}()
-
 	// 并发处理每个符号
-	for _, symbol := range symbols {
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(sym string) {
-			defer wg.Done()
-			defer func() { <-sem }()
-			stockData, err := database.QueryStockData(db, sym, nil, nil)
-			if err != nil {
-				results <- result{"", fmt.Errorf("failed to query stock data for symbol %s: %w", sym, err)}
-				return
-			}
-			xdxrData := getXdxrByCode(xdxrIndex, sym)
+	go func() {
+		for _, symbol := range symbols {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(sym string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				stockData, err := database.QueryStockData(db, sym, nil, nil)
+				if err != nil {
+					results <- result{nil, fmt.Errorf("failed to query stock data for symbol %s: %w", sym, err)}
+					return
+				}
+				xdxrData := getXdxrByCode(xdxrIndex, sym)
 
-			factors, err := tdx.CalculateFqFactor(stockData, xdxrData)
-			if err != nil {
-				results <- result{"", fmt.Errorf("failed to calculate factor for symbol %s: %w", sym, err)}
-				return
-			}
-			// 将因子格式化为 CSV 行
-			var sb strings.Builder
-			for _, factor := range factors {
-				row := fmt.Sprintf("%s,%s,%.4f,%.4f,%.4f,%.4f\n",
-					factor.Symbol,
-					factor.Date.Format("2006-01-02"),
-					factor.Close,
-					factor.PreClose,
-					factor.QfqFactor,
-					factor.HfqFactor,
-				)
-				sb.WriteString(row)
-			}
-			results <- result{sb.String(), nil}
-		}(symbol)
-	}
+				factors, err := tdx.CalculateFqFactor(stockData, xdxrData)
+				if err != nil {
+					results <- result{nil, fmt.Errorf("failed to calculate factor for symbol %s: %w", sym, err)}
+					return
+				}
+				results <- result{factors, nil}
+			}(symbol)
+		}
 
-	// 等待所有处理n完成并关闭结果通道
-	go func() {
+		// 等待所有处理完成并关闭结果通道
 		wg.Wait()
 		close(results)
 	}()
 
-	// 等待写入协程完成
-	writerWg.Wait()
-
-	if err := database.ImportFactorCsv(db, csvPath); err != nil {
-		return fmt.Errorf("failed to import factor data: %w", err)
+	var emitErr error
+	for res := range results {
+		if res.err != nil {
+			fmt.Printf("错误：%v\n", res.err)
+			continue
+		}
+		if emitErr != nil {
+			continue
+		}
+		for _, factor := range res.factors {
+			if err := emit(factor); err != nil {
+				emitErr = err
+				break
+			}
+		}
 	}
-	fmt.Println("🔢 复权因子导入成功")
 
-	return nil
+	return emitErr
 }
 
 func buildXdxrIndex(db *sql.DB) (XdxrIndex, error) {
 	index := make(XdxrIndex)
 
-	xdxrData, err := database.QueryAllXdxr(db)
+	xdxrData, err := database.QueryEffectiveXdxr(db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query xdxr data: %w", err)
 	}
@@ -292,28 +415,22 @@ func getXdxrByCode(index XdxrIndex, symbol string) []model.XdxrData {
 	return []model.XdxrData{}
 }
 
-func prepareTdxData(latestDate time.Time, dataType string) ([]time.Time, error) {
-	var dates []time.Time
-
-	for d := latestDate.Add(24 * time.Hour); !d.After(Today); d = d.Add(24 * time.Hour) {
-		dates = append(dates, d)
-	}
-
-	if len(dates) == 0 {
+// prepareTdxData 获取交易日历中预期开市日期的数据文件并转档，返回每个日期的处理结果。
+// 某个日期失败后不再处理之后的日期，避免导入后最新日期越过缺失的交易日。
+func prepareTdxData(source DataSource, tradeDays []database.TradeDay, dataType DataKind) ([]DateOutcome, error) {
+	if len(tradeDays) == 0 {
 		return nil, nil
 	}
 
-	var targetPath, urlTemplate, fileSuffix, dataTypeCN string
+	var targetPath, fileSuffix, dataTypeCN string
 
 	switch dataType {
-	case "day":
+	case DayKind:
 		targetPath = filepath.Join(VipdocDir, "refmhq")
-		urlTemplate = "https://www.tdx.com.cn/products/data/data/g4day/%s.zip"
 		fileSuffix = "day"
 		dataTypeCN = "日线"
-	case "tic":
+	case TicKind:
 		targetPath = filepath.Join(VipdocDir, "newdatetick")
-		urlTemplate = "https://www.tdx.com.cn/products/data/data/g4tic/%s.zip"
 		fileSuffix = "tic"
 		dataTypeCN = "分时"
 	default:
@@ -324,68 +441,94 @@ func prepareTdxData(latestDate time.Time, dataType string) ([]time.Time, error)
 		return nil, fmt.Errorf("failed to create target directory: %w", err)
 	}
 
-	fmt.Printf("🐢 开始下载%s数据\n", dataTypeCN)
+	fmt.Printf("🐢 开始获取%s数据\n", dataTypeCN)
+
+	outcomes := make([]DateOutcome, 0, len(tradeDays))
+	failed := false
 
-	validDates := make([]time.Time, 0, len(dates))
+	for _, day := range tradeDays {
+		date := day.Date
+		outcome := DateOutcome{Kind: dataType, Date: date}
+		if failed {
+			outcome.Status = FetchSkipped
+			outcome.Reason = "之前的日期获取失败"
+			outcomes = append(outcomes, outcome)
+			continue
+		}
 
-	for _, date := range dates {
 		dateStr := date.Format("20060102")
-		url := fmt.Sprintf(urlTemplate, dateStr)
 		fileName := fmt.Sprintf("%s%s.zip", dateStr, fileSuffix)
 		filePath := filepath.Join(targetPath, fileName)
 
-		status, err := utils.DownloadFile(url, filePath)
-		switch status {
-		case 200:
-
+		status, err := source.Fetch(dataType, date, filePath)
+		switch {
+		case err == nil && status == 200:
 			fmt.Printf("✅ 已下载 %s 的数据\n", dateStr)
 
 			if err := utils.UnzipFile(filePath, targetPath); err != nil {
 				fmt.Printf("⚠️ 解压文件 %s 失败: %v\n", filePath, err)
-				continue
+				outcome.Status = FetchUnzipFailed
+				outcome.Reason = err.Error()
+				failed = true
+			} else {
+				outcome.Status = FetchDownloaded
 			}
-
-			validDates = append(validDates, date)
-		case 404:
+		case err == nil && status == 404 && day.Source == database.CalendarWeekday:
+			// 休市安排未收录的日期无法区分节假日和缺失
 			fmt.Printf("🟡 %s 非交易日或数据尚未更新\n", dateStr)
-			continue
+			outcome.Status = FetchNotTradingDay
+		case err == nil && status == 404 && !date.Before(Today):
+			fmt.Printf("🟡 已过发布时间，%s 的数据仍未发布\n", dateStr)
+			outcome.Status = FetchNotPublished
+		case err == nil && status == 404:
+			fmt.Printf("🚨 %s 是交易日但没有数据，请检查数据源或在 %s 中将其标记为休市\n", dateStr, database.TradeCalendarSchema.Name)
+			outcome.Status = FetchMissing
+			outcome.Reason = "预期交易日返回 404"
+			failed = true
 		default:
-			if err != nil {
-				return nil, nil
+			if err == nil {
+				err = fmt.Errorf("unexpected status: %d", status)
 			}
+			fmt.Printf("🛑 %s 获取失败: %v\n", dateStr, err)
+			outcome.Status = FetchFailed
+			outcome.Reason = err.Error()
+			failed = true
 		}
-
+		outcomes = append(outcomes, outcome)
 	}
 
+	validDates := downloadedDates(outcomes)
 	if len(validDates) > 0 {
 		endDate := validDates[len(validDates)-1]
 		switch dataType {
-		case "day":
+		case DayKind:
 			if err := tdx.DatatoolCreate(DataDir, "day", endDate); err != nil {
-				return nil, fmt.Errorf("failed to run DatatoolDayCreate: %w", err)
+				return outcomes, fmt.Errorf("failed to run DatatoolDayCreate: %w", err)
 			}
 
-		case "tic":
-			endDate := validDates[len(validDates)-1]
+		case TicKind:
 			fmt.Printf("🐢 开始转档分笔数据\n")
 			if err := tdx.DatatoolCreate(DataDir, "tick", endDate); err != nil {
-				return nil, fmt.Errorf("failed to run DatatoolTickCreate: %w", err)
+				return outcomes, fmt.Errorf("failed to run DatatoolTickCreate: %w", err)
 			}
 			fmt.Printf("🐢 开始转换分钟数据\n")
 			if err := tdx.DatatoolCreate(DataDir, "min", endDate); err != nil {
-				return nil, fmt.Errorf("failed to run DatatoolMinCreate: %w", err)
+				return outcomes, fmt.Errorf("failed to run DatatoolMinCreate: %w", err)
 			}
 		}
 	}
 
-	return validDates, nil
+	return outcomes, nil
 }
 
-func getGbbqFile(cacheDir string) (string, error) {
+func getGbbqFile(source DataSource, cacheDir string) (string, error) {
 	zipPath := filepath.Join(cacheDir, "gbbq.zip")
-	gbbqURL := "http://www.tdx.com.cn/products/data/data/dbf/gbbq.zip"
-	if _, err := utils.DownloadFile(gbbqURL, zipPath); err != nil {
-		return "", fmt.Errorf("failed to download GBBQ zip file: %w", err)
+	status, err := source.Fetch(GbbqKind, Today, zipPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch GBBQ zip file: %w", err)
+	}
+	if status == 404 {
+		return "", fmt.Errorf("GBBQ zip file not found")
 	}
 
 	unzipPath := filepath.Join(cacheDir, "gbbq-temp")
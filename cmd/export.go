@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jing2uo/tdx2db/database"
+	"github.com/jing2uo/tdx2db/model"
+)
+
+const manifestName = "manifest.json"
+
+type ExportOptions struct {
+	DBPath string
+	OutDir string
+	// Tables 为 database.ExportTables 中的名称，为空时导出全部
+	Tables      []string
+	PartitionBy string
+	// Full 为 true 时忽略清单，重新导出所有分区
+	Full bool
+}
+
+// exportManifest 记录上次导出的分区指纹，保存在输出目录中
+type exportManifest struct {
+	PartitionBy string                                              `json:"partition_by"`
+	Tables      map[string]map[string]database.PartitionFingerprint `json:"tables"`
+}
+
+// Export 将数据库导出为按 Hive 风格分区的 parquet 文件，只重写与上次导出相比有变化的分区
+func Export(opts ExportOptions) error {
+	if opts.DBPath == "" {
+		return fmt.Errorf("database path cannot be empty")
+	}
+	if opts.OutDir == "" {
+		return errors.New("output path cannot be empty")
+	}
+	switch opts.PartitionBy {
+	case database.PartitionByMonth, database.PartitionByPrefix:
+	default:
+		return fmt.Errorf("unsupported partition: %s", opts.PartitionBy)
+	}
+
+	tables, err := selectExportTables(opts.Tables)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	manifestPath := filepath.Join(opts.OutDir, manifestName)
+	manifest, err := loadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if manifest.PartitionBy != opts.PartitionBy {
+		if len(manifest.Tables) > 0 {
+			fmt.Printf("⚠️ 分区方式由 %s 改为 %s，重新导出\n", manifest.PartitionBy, opts.PartitionBy)
+		}
+		opts.Full = true
+	}
+
+	dbConfig := model.DBConfig{Path: opts.DBPath}
+	db, err := database.Connect(dbConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	for _, t := range tables {
+		exists, err := database.ExportTableExists(db, t)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			fmt.Printf("🟡 %s 表不存在，跳过\n", t.Schema.Name)
+			continue
+		}
+
+		tableDir := filepath.Join(opts.OutDir, t.Name)
+		previous := manifest.Tables[t.Name]
+		if opts.Full {
+			if err := os.RemoveAll(tableDir); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", tableDir, err)
+			}
+			previous = nil
+		}
+
+		current, err := database.PartitionFingerprints(db, t, opts.PartitionBy)
+		if err != nil {
+			return err
+		}
+
+		written, removed, err := exportPartitions(db, t, opts.PartitionBy, tableDir, previous, current)
+		if err != nil {
+			return err
+		}
+
+		// 每张表完成后保存清单，中断后重新执行只需处理剩余的分区
+		manifest.PartitionBy = opts.PartitionBy
+		manifest.Tables[t.Name] = current
+		if err := saveManifest(manifestPath, manifest); err != nil {
+			return err
+		}
+		fmt.Printf("📦 %s: 共 %d 个分区，写入 %d 个，删除 %d 个\n", t.Name, len(current), written, removed)
+	}
+
+	fmt.Printf("🚀 导出完成: %s\n", opts.OutDir)
+	return nil
+}
+
+// exportPartitions 写入指纹有变化的分区，删除数据库中已不存在的分区。
+// 有变化的分区一次写入临时目录，再逐个替换到 tableDir 中。
+func exportPartitions(db *sql.DB, t database.ExportTable, by, tableDir string,
+	previous, current map[string]database.PartitionFingerprint) (written, removed int, err error) {
+
+	var changed []string
+	for p, fp := range current {
+		if old, ok := previous[p]; !ok || old != fp {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+
+	if len(changed) > 0 {
+		// 临时目录与输出在同一文件系统，保证可以直接重命名
+		staging := filepath.Join(filepath.Dir(tableDir), ".staging-"+t.Name)
+		if err := os.RemoveAll(staging); err != nil {
+			return written, removed, fmt.Errorf("failed to remove %s: %w", staging, err)
+		}
+		defer os.RemoveAll(staging)
+
+		if err := database.ExportPartitions(db, t, by, changed, staging); err != nil {
+			return written, removed, err
+		}
+		for _, p := range changed {
+			src := filepath.Join(staging, filepath.FromSlash(p))
+			dst := filepath.Join(tableDir, filepath.FromSlash(p))
+			if err := replacePartition(src, dst); err != nil {
+				return written, removed, err
+			}
+			written++
+		}
+	}
+
+	for p := range previous {
+		if _, ok := current[p]; ok {
+			continue
+		}
+		dir := filepath.Join(tableDir, filepath.FromSlash(p))
+		if err := os.RemoveAll(dir); err != nil {
+			return written, removed, fmt.Errorf("failed to remove partition %s: %w", p, err)
+		}
+		// 删除变空的上级目录，如 year=2025
+		for parent := filepath.Dir(dir); parent != tableDir; parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+		removed++
+	}
+	return written, removed, nil
+}
+
+// replacePartition 将 src 中的 parquet 文件移动到 dst，替换 dst 中原有的文件。
+// 只有一个文件时命名为 data.parquet，与逐个分区导出时的布局相同。
+func replacePartition(src, dst string) error {
+	files, err := filepath.Glob(filepath.Join(src, "*.parquet"))
+	if err != nil || len(files) == 0 {
+		return fmt.Errorf("no exported files in %s", src)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dst, err)
+	}
+
+	keep := make(map[string]bool, len(files))
+	for i, f := range files {
+		name := "data.parquet"
+		if len(files) > 1 {
+			name = fmt.Sprintf("data_%d.parquet", i)
+		}
+		target := filepath.Join(dst, name)
+		if err := os.Rename(f, target); err != nil {
+			return fmt.Errorf("failed to rename %s: %w", f, err)
+		}
+		keep[name] = true
+	}
+
+	old, err := filepath.Glob(filepath.Join(dst, "*.parquet"))
+	if err != nil {
+		return err
+	}
+	for _, f := range old {
+		if !keep[filepath.Base(f)] {
+			if err := os.Remove(f); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", f, err)
+			}
+		}
+	}
+	return nil
+}
+
+func selectExportTables(names []string) ([]database.ExportTable, error) {
+	if len(names) == 0 {
+		return database.ExportTables, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var tables []database.ExportTable
+	for _, t := range database.ExportTables {
+		if wanted[t.Name] {
+			tables = append(tables, t)
+			delete(wanted, t.Name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown table: %s", name)
+	}
+	return tables, nil
+}
+
+func loadManifest(path string) (*exportManifest, error) {
+	manifest := &exportManifest{Tables: make(map[string]map[string]database.PartitionFingerprint)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	if manifest.Tables == nil {
+		manifest.Tables = make(map[string]map[string]database.PartitionFingerprint)
+	}
+	return manifest, nil
+}
+
+func saveManifest(path string, manifest *exportManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -10,34 +10,53 @@ import (
 	"github.com/jing2uo/tdx2db/utils"
 )
 
-func Init(dbPath, dayFileDir string) error {
+type InitOptions struct {
+	DBPath     string
+	DayFileDir string
+	// Universe 为导入范围名称，会保存到数据库供 cron 使用
+	Universe []string
+	// Filter 进一步限定导入的代码和日期，Prefixes 与导入范围取交集
+	Filter tdx.Filter
+}
+
+func Init(opts InitOptions) error {
 
-	if dbPath == "" {
+	if opts.DBPath == "" {
 		return fmt.Errorf("database path cannot be empty")
 	}
 
-	fmt.Printf("📦 开始处理日线目录: %s\n", dayFileDir)
-	err := utils.CheckDirectory(dayFileDir)
+	fmt.Printf("📦 开始处理日线目录: %s\n", opts.DayFileDir)
+	err := utils.CheckDirectory(opts.DayFileDir)
 	if err != nil {
 		return err
 	}
-	fmt.Println("🐢 开始转换日线数据")
-	_, err = tdx.ConvertFiles2Csv(dayFileDir, ValidPrefixes, StockCSV, ".day")
-	if err != nil {
-		return fmt.Errorf("failed to convert day files to CSV: %w", err)
-	}
 
-	fmt.Println("🔥 转换完成")
-
-	dbConfig := model.DBConfig{Path: dbPath}
+	dbConfig := model.DBConfig{Path: opts.DBPath}
 	db, err := database.Connect(dbConfig)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 	defer db.Close()
 
-	if err := database.ImportStockCsv(db, StockCSV); err != nil {
-		return fmt.Errorf("failed to import stock CSV: %w", err)
+	prefixes, err := resolveUniverse(db, opts.Universe, true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve universe: %w", err)
+	}
+	filter := opts.Filter
+	filter.Prefixes = NarrowPrefixes(prefixes, opts.Filter.Prefixes)
+	if len(filter.Prefixes) == 0 {
+		return fmt.Errorf("prefixes %v are outside the import range", opts.Filter.Prefixes)
+	}
+
+	fmt.Println("🐢 开始导入日线数据")
+	if err := database.ImportStocks(db, fileSource(opts.DayFileDir, filter, ".day")); err != nil {
+		return fmt.Errorf("failed to import day files: %w", err)
+	}
+	if err := refreshTradeCalendar(db); err != nil {
+		return err
+	}
+	if err := refreshSymbols(db); err != nil {
+		return err
 	}
 	fmt.Println("🚀 股票数据导入成功")
 	return nil
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/jing2uo/tdx2db/database"
+	"github.com/jing2uo/tdx2db/model"
+)
+
+// Migrate 将数据库结构升级到当前版本，连接数据库时会自动执行迁移
+func Migrate(dbPath string) error {
+	if dbPath == "" {
+		return fmt.Errorf("database path cannot be empty")
+	}
+
+	dbConfig := model.DBConfig{Path: dbPath}
+	db, err := database.Connect(dbConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	version, err := database.GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🚀 数据库结构版本为 v%d\n", version)
+	return nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// FetchStatus 表示某个日期的数据文件获取结果
+type FetchStatus int
+
+const (
+	FetchDownloaded    FetchStatus = iota // 已下载并解压
+	FetchNotTradingDay                    // 文件不存在，非交易日或数据尚未更新
+	FetchFailed                           // 下载失败
+	FetchUnzipFailed                      // 解压失败
+	FetchSkipped                          // 之前的日期失败，为避免数据缺口未处理
+	FetchNotPublished                     // 今天是交易日，数据尚未发布
+	FetchMissing                          // 已过去的交易日没有数据
+)
+
+func (s FetchStatus) String() string {
+	switch s {
+	case FetchDownloaded:
+		return "已下载"
+	case FetchNotTradingDay:
+		return "非交易日或数据尚未更新"
+	case FetchFailed:
+		return "下载失败"
+	case FetchUnzipFailed:
+		return "解压失败"
+	case FetchSkipped:
+		return "已跳过"
+	case FetchNotPublished:
+		return "数据尚未发布"
+	case FetchMissing:
+		return "交易日数据缺失"
+	default:
+		return "未知"
+	}
+}
+
+// Failed 表示该日期需要重新处理
+func (s FetchStatus) Failed() bool {
+	return s == FetchFailed || s == FetchUnzipFailed || s == FetchSkipped || s == FetchMissing
+}
+
+// DateOutcome 记录 cron 处理某类数据某一天的结果
+type DateOutcome struct {
+	Kind   DataKind
+	Date   time.Time
+	Status FetchStatus
+	Reason string
+}
+
+var dataKindCN = map[DataKind]string{
+	DayKind:  "日线",
+	TicKind:  "分时",
+	GbbqKind: "股本变迁",
+}
+
+// downloadedDates 返回成功获取数据的日期
+func downloadedDates(outcomes []DateOutcome) []time.Time {
+	var dates []time.Time
+	for _, o := range outcomes {
+		if o.Status == FetchDownloaded {
+			dates = append(dates, o.Date)
+		}
+	}
+	return dates
+}
+
+// failedOutcomes 返回需要重新处理的日期
+func failedOutcomes(outcomes []DateOutcome) []DateOutcome {
+	var failed []DateOutcome
+	for _, o := range outcomes {
+		if o.Status.Failed() {
+			failed = append(failed, o)
+		}
+	}
+	return failed
+}
+
+// printFetchReport 以表格形式输出每个日期的处理结果，结果相同的连续日期合并为一行
+func printFetchReport(outcomes []DateOutcome) {
+	if len(outcomes) == 0 {
+		return
+	}
+
+	fmt.Println("📋 数据获取报告")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "类型\t日期\t结果\t原因")
+	for start := 0; start < len(outcomes); {
+		o := outcomes[start]
+		end := start + 1
+		for end < len(outcomes) && outcomes[end].Kind == o.Kind &&
+			outcomes[end].Status == o.Status && outcomes[end].Reason == o.Reason {
+			end++
+		}
+
+		dates := o.Date.Format("2006-01-02")
+		if end-start > 1 {
+			dates += " ~ " + outcomes[end-1].Date.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", dataKindCN[o.Kind], dates, o.Status, o.Reason)
+		start = end
+	}
+	w.Flush()
+}
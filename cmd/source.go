@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jing2uo/tdx2db/utils"
+)
+
+// DataKind 表示 cron 需要获取的数据文件类型
+type DataKind string
+
+const (
+	DayKind  DataKind = "day"  // 四代行情 g4day 每日 zip
+	TicKind  DataKind = "tic"  // 四代 TIC g4tic 每日 zip
+	GbbqKind DataKind = "gbbq" // 股本变迁 gbbq.zip
+)
+
+// DataSource 提供 cron 所需的数据文件。
+// Fetch 将指定类型和日期的文件保存到 targetPath，返回 HTTP 语义的状态码：
+// 200 表示成功，404 表示文件不存在（非交易日或数据尚未更新），其他状态码伴随 error。
+// GbbqKind 忽略 date。
+type DataSource interface {
+	Fetch(kind DataKind, date time.Time, targetPath string) (int, error)
+}
+
+// HTTPSource 从通达信官网或镜像下载数据。
+// URL 模板中的 {mirror} 会依次替换为 Mirrors 中的地址，{date} 替换为 YYYYMMDD，
+// 前一个镜像网络错误、返回 429 或 5xx 时尝试下一个。
+type HTTPSource struct {
+	Mirrors      []string
+	URLTemplates map[DataKind]string
+	// Cache 不为空时使用持久化下载缓存
+	Cache *utils.DownloadCache
+}
+
+// DefaultMirrors 默认镜像列表，https 不可用时回退到 http
+var DefaultMirrors = []string{
+	"https://www.tdx.com.cn/products/data/data",
+	"http://www.tdx.com.cn/products/data/data",
+}
+
+var DefaultURLTemplates = map[DataKind]string{
+	DayKind:  "{mirror}/g4day/{date}.zip",
+	TicKind:  "{mirror}/g4tic/{date}.zip",
+	GbbqKind: "{mirror}/dbf/gbbq.zip",
+}
+
+// NewHTTPSource 创建 HTTPSource，mirrors 或 templates 中缺少的项使用默认值。
+// 镜像地址去掉首尾空白，空项忽略，便于传入 "a, b" 这样的逗号分隔列表。
+func NewHTTPSource(mirrors []string, templates map[DataKind]string) HTTPSource {
+	s := HTTPSource{
+		Mirrors:      DefaultMirrors,
+		URLTemplates: make(map[DataKind]string, len(DefaultURLTemplates)),
+	}
+	var trimmed []string
+	for _, mirror := range mirrors {
+		if mirror = strings.TrimSpace(mirror); mirror != "" {
+			trimmed = append(trimmed, mirror)
+		}
+	}
+	if len(trimmed) > 0 {
+		s.Mirrors = trimmed
+	}
+	for kind, tmpl := range DefaultURLTemplates {
+		s.URLTemplates[kind] = tmpl
+		if t, ok := templates[kind]; ok && t != "" {
+			s.URLTemplates[kind] = t
+		}
+	}
+	return s
+}
+
+// URLs 返回按镜像顺序展开后的下载地址
+func (s HTTPSource) URLs(kind DataKind, date time.Time) ([]string, error) {
+	tmpl, ok := s.URLTemplates[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown data kind: %s", kind)
+	}
+
+	dateStr := date.Format("20060102")
+	var urls []string
+	for _, mirror := range s.Mirrors {
+		url := strings.ReplaceAll(tmpl, "{mirror}", strings.TrimSuffix(mirror, "/"))
+		url = strings.ReplaceAll(url, "{date}", dateStr)
+		urls = append(urls, url)
+		if !strings.Contains(tmpl, "{mirror}") {
+			// 模板是完整地址时只有一个候选
+			break
+		}
+	}
+	return urls, nil
+}
+
+func (s HTTPSource) Fetch(kind DataKind, date time.Time, targetPath string) (int, error) {
+	urls, err := s.URLs(kind, date)
+	if err != nil {
+		return 0, err
+	}
+	if s.Cache != nil {
+		// gbbq.zip 的 URL 不含日期且随时更新，每次都用条件 GET 重新校验
+		return s.Cache.Fetch(urls, date.Format("20060102"), targetPath, kind == GbbqKind)
+	}
+	return utils.DownloadFile(urls, targetPath)
+}
+
+// DirSource 从本地目录读取数据，目录结构与官网路径一致：
+//
+//	<Dir>/g4day/YYYYMMDD.zip
+//	<Dir>/g4tic/YYYYMMDD.zip
+//	<Dir>/gbbq.zip
+type DirSource struct {
+	Dir string
+}
+
+func (s DirSource) Fetch(kind DataKind, date time.Time, targetPath string) (int, error) {
+	var srcPath string
+	switch kind {
+	case DayKind:
+		srcPath = filepath.Join(s.Dir, "g4day", date.Format("20060102")+".zip")
+	case TicKind:
+		srcPath = filepath.Join(s.Dir, "g4tic", date.Format("20060102")+".zip")
+	case GbbqKind:
+		srcPath = filepath.Join(s.Dir, "gbbq.zip")
+	default:
+		return 0, fmt.Errorf("unknown data kind: %s", kind)
+	}
+
+	if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+		return 404, nil
+	}
+	if err := utils.CopyFile(srcPath, targetPath); err != nil {
+		return 0, fmt.Errorf("failed to copy %s: %w", srcPath, err)
+	}
+	return 200, nil
+}
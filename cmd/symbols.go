@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jing2uo/tdx2db/database"
+	"github.com/jing2uo/tdx2db/tdx"
+)
+
+// refreshSymbols 根据日线数据刷新代码维表 raw_symbols，分类按通达信代码规则推断
+func refreshSymbols(db *sql.DB) error {
+	classify := func(symbol string) (string, string, string) {
+		class := tdx.ClassifySymbol(symbol)
+		return class.Exchange, class.Board, class.Type
+	}
+	if err := database.RefreshSymbols(db, classify); err != nil {
+		return fmt.Errorf("failed to refresh symbols: %w", err)
+	}
+	return nil
+}
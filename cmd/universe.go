@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jing2uo/tdx2db/database"
+)
+
+// DefaultUniverse 为未指定 --universe 时的导入范围：A 股、主要指数和通达信板块
+const DefaultUniverse = "default"
+
+// Universes 为可选的导入范围，值为代码前缀
+var Universes = map[string][]string{
+	DefaultUniverse: {
+		"sz30",     // 创业板
+		"sz00",     // 深证主板
+		"sh60",     // 上证主板
+		"sh68",     // 科创板
+		"bj920",    // 北证
+		"sh000300", // 沪深300
+		"sh000905", // 中证500
+		"sh000852", // 中证1000
+		"sh000001", // 上证指数
+		"sz399001", // 深证指数
+		"sz399006", // 创业板指
+		"sh000680", // 科创综指
+		"bj899050", // 北证50
+		"sh880",    // 通达信概念、风格板块
+		"sh881",    // 通达信行业
+	},
+	"stocks": {
+		"sh60",  // 上证主板
+		"sh68",  // 科创板
+		"sz00",  // 深证主板
+		"sz30",  // 创业板
+		"bj920", // 北证
+	},
+	"bshares": {
+		"sh900", // 上证 B 股
+		"sz200", // 深证 B 股
+	},
+	"indices": {
+		"sh000", // 上证、中证指数
+		"sz399", // 深证指数
+		"bj899", // 北证指数
+	},
+	"etf": {
+		"sh50", // 上证 LOF、封闭式基金
+		"sh51", // 上证 ETF
+		"sh52", // 上证 ETF
+		"sh56", // 上证 ETF
+		"sh58", // 上证科创板 ETF
+		"sz15", // 深证 ETF
+		"sz16", // 深证 LOF
+	},
+	"cb": {
+		"sh110", // 上证可转债
+		"sh111", // 上证可转债
+		"sh113", // 上证可转债
+		"sh118", // 上证科创板可转债
+		"sz123", // 深证创业板可转债
+		"sz127", // 深证可转债
+		"sz128", // 深证可转债
+	},
+	"blocks": {
+		"sh880", // 通达信概念、风格板块
+		"sh881", // 通达信行业
+	},
+	"all": {"sh", "sz", "bj"},
+}
+
+// UniverseNames 返回所有可选范围的名称
+func UniverseNames() []string {
+	names := make([]string, 0, len(Universes))
+	for name := range Universes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UniversePrefixes 合并多个范围的代码前缀
+func UniversePrefixes(names []string) ([]string, error) {
+	var prefixes []string
+	for _, name := range names {
+		list, ok := Universes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown universe %q, available: %s", name, strings.Join(UniverseNames(), ", "))
+		}
+		for _, p := range list {
+			if !slices.Contains(prefixes, p) {
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+// resolveUniverse 返回本次导入使用的代码前缀。未指定 names 时使用数据库中保存的范围，
+// 都没有时使用默认范围。save 为 true 时（init）将范围保存到数据库，cron 和 backfill
+// 指定的范围只对本次运行有效。
+func resolveUniverse(db *sql.DB, names []string, save bool) ([]string, error) {
+	stored, ok, err := database.GetSetting(db, database.SettingUniverse)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		names = []string{DefaultUniverse}
+		if ok {
+			names = strings.Split(stored, ",")
+		}
+	}
+
+	prefixes, err := UniversePrefixes(names)
+	if err != nil {
+		return nil, err
+	}
+
+	value := strings.Join(names, ",")
+	if save && (!ok || value != stored) {
+		if ok {
+			fmt.Printf("⚠️ 导入范围由 %s 改为 %s\n", stored, value)
+		}
+		if err := database.SetSetting(db, database.SettingUniverse, value); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("🌐 导入范围: %s\n", value)
+	return prefixes, nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jing2uo/tdx2db/database"
+	"github.com/jing2uo/tdx2db/model"
+	"github.com/jing2uo/tdx2db/tdx"
+)
+
+type VerifyOptions struct {
+	DBPath string
+	// JSON 为 true 时以 JSON 输出结果
+	JSON bool
+	// Limit 为每项检查最多列出的问题数，0 表示全部列出
+	Limit int
+}
+
+// VerifyResult 为一项检查的结果
+type VerifyResult struct {
+	Check  string                 `json:"check"`
+	Table  string                 `json:"table"`
+	Count  int                    `json:"count"`
+	Issues []database.VerifyIssue `json:"issues"`
+	// Info 为 true 时结果只供核对，不计入问题总数
+	Info bool `json:"info,omitempty"`
+}
+
+var verifyCheckCN = map[string]string{
+	database.CheckMissingDates:      "缺失日期",
+	database.CheckSuspendedDates:    "停牌日期（仅供核对）",
+	database.CheckDuplicateKeys:     "重复主键",
+	database.CheckMinuteBarCount:    "分钟 K 线数量不完整",
+	database.CheckAggregateMismatch: "分钟汇总与日线不一致（分钟/日线）",
+	database.CheckOHLCInvariants:    "OHLC 不合理",
+	database.CheckMissingFactors:    "缺少复权因子",
+}
+
+// Verify 检查数据库中的缺失日期、重复主键和数据一致性，只报告问题，不修改数据
+func Verify(opts VerifyOptions) error {
+	if opts.DBPath == "" {
+		return fmt.Errorf("database path cannot be empty")
+	}
+
+	dbConfig := model.DBConfig{Path: opts.DBPath}
+	db, err := database.Connect(dbConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	holidays, err := tdx.LoadSSEHolidays()
+	if err != nil {
+		return fmt.Errorf("failed to load SSE holidays: %w", err)
+	}
+	// 只有休市安排收录的年份能确定哪天开市，未收录的年份不检查整天缺失
+	rule := func(date time.Time) (bool, string) {
+		if holidays.Covers(date) {
+			return holidays.IsTradingDay(date), database.CalendarSSE
+		}
+		return holidays.IsTradingDay(date), database.CalendarWeekday
+	}
+
+	checks, err := database.VerifyChecks(db, rule)
+	if err != nil {
+		return err
+	}
+
+	results := make([]VerifyResult, 0, len(checks))
+	for _, check := range checks {
+		count, issues, err := database.RunVerifyCheck(db, check, opts.Limit)
+		if err != nil {
+			return err
+		}
+		if issues == nil {
+			issues = []database.VerifyIssue{}
+		}
+		result := VerifyResult{Check: check.Name, Table: check.Table, Count: count, Issues: issues, Info: check.Info}
+		results = append(results, result)
+		if !opts.JSON {
+			printVerifyResult(result)
+		}
+	}
+
+	if opts.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(results)
+	}
+
+	total := 0
+	for _, r := range results {
+		if !r.Info {
+			total += r.Count
+		}
+	}
+	if total == 0 {
+		fmt.Println("🚀 没有发现问题")
+	} else {
+		fmt.Printf("⚠️ 共发现 %d 个问题\n", total)
+	}
+	return nil
+}
+
+func printVerifyResult(r VerifyResult) {
+	if r.Count == 0 {
+		fmt.Printf("✅ %s %s: 0\n", r.Table, verifyCheckCN[r.Check])
+		return
+	}
+
+	icon := "⚠️"
+	if r.Info {
+		icon = "ℹ️"
+	}
+	fmt.Printf("%s %s %s: %d\n", icon, r.Table, verifyCheckCN[r.Check], r.Count)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, issue := range r.Issues {
+		fmt.Fprintf(w, "   %s\t%s\t%s\n", issue.Symbol, issue.Date, issue.Detail)
+	}
+	w.Flush()
+	if more := r.Count - len(r.Issues); more > 0 {
+		fmt.Printf("   ... 还有 %d 条\n", more)
+	}
+}
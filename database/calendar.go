@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+)
+
+// TradeCalendarSchema 交易日历，每个自然日一行
+var TradeCalendarSchema = TableSchema{
+	Name: "raw_trade_calendar",
+	Columns: []string{
+		"date DATE",
+		"is_open BOOLEAN",
+		"source VARCHAR",
+	},
+	PrimaryKey: []string{"date"},
+}
+
+// 交易日历的来源
+const (
+	CalendarObserved = "observed" // 日线表中的实际数据
+	CalendarSSE      = "sse"      // 上交所休市安排
+	CalendarWeekday  = "weekday"  // 休市安排未收录，只按周末推算
+	CalendarManual   = "manual"   // 手动维护，刷新时保留
+)
+
+// TradeDay 为交易日历中的一天
+type TradeDay struct {
+	Date   time.Time
+	IsOpen bool
+	Source string
+}
+
+// TradeDayRule 推算日线数据之后的日期是否开市，并返回推算依据
+type TradeDayRule func(date time.Time) (open bool, source string)
+
+// RefreshTradeCalendar 刷新交易日历至 through。日线数据覆盖的范围以实际数据为准，
+// 之后的日期按 rule 推算；source 为 manual 的行不会被推算结果覆盖。
+func RefreshTradeCalendar(db *sql.DB, rule TradeDayRule, through time.Time) error {
+	if err := CreateTable(db, TradeCalendarSchema); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	observed, err := queryDateSet(db, fmt.Sprintf("SELECT DISTINCT date FROM %s", StocksSchema.Name))
+	if err != nil {
+		return fmt.Errorf("failed to query observed trading days: %w", err)
+	}
+	if len(observed) == 0 {
+		return nil
+	}
+	manual, err := queryDateSet(db, fmt.Sprintf("SELECT date FROM %s WHERE source = '%s'", TradeCalendarSchema.Name, CalendarManual))
+	if err != nil {
+		return fmt.Errorf("failed to query manual calendar entries: %w", err)
+	}
+
+	var first, last time.Time
+	for d := range observed {
+		if first.IsZero() || d.Before(first) {
+			first = d
+		}
+		if d.After(last) {
+			last = d
+		}
+	}
+
+	return UpsertRows(db, TradeCalendarSchema, func(appendRow func(values ...driver.Value) error) error {
+		for d := first; !d.After(through) || !d.After(last); d = d.AddDate(0, 0, 1) {
+			open, source := observed[d], CalendarObserved
+			if d.After(last) {
+				if manual[d] {
+					continue
+				}
+				open, source = rule(d)
+			}
+			if err := appendRow(d, open, source); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// QueryTradeDays 返回 [from, to] 范围内开市的日期
+func QueryTradeDays(db *sql.DB, from, to time.Time) ([]TradeDay, error) {
+	query := fmt.Sprintf(`
+		SELECT date, is_open, source FROM %s
+		WHERE is_open AND date BETWEEN ? AND ?
+		ORDER BY date
+	`, TradeCalendarSchema.Name)
+
+	rows, err := db.Query(query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trade calendar: %w", err)
+	}
+	defer rows.Close()
+
+	var days []TradeDay
+	for rows.Next() {
+		var day TradeDay
+		if err := rows.Scan(&day.Date, &day.IsOpen, &day.Source); err != nil {
+			return nil, fmt.Errorf("failed to scan trade calendar row: %w", err)
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+func queryDateSet(db *sql.DB, query string) (map[time.Time]bool, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dates := make(map[time.Time]bool)
+	for rows.Next() {
+		var d time.Time
+		if err := rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		dates[d] = true
+	}
+	return dates, rows.Err()
+}
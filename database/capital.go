@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+	"github.com/jing2uo/tdx2db/model"
+)
+
+// CapitalSchema 股本结构历史，每次股本变动一行，股本单位为万股。
+// valid_from 包含、valid_to 不包含，当前股本的 valid_to 为 NULL，
+// 任意日期或分钟时间的股本可以直接用范围连接查询。
+var CapitalSchema = TableSchema{
+	Name: "raw_capital",
+	Columns: []string{
+		"code VARCHAR",
+		"valid_from DATE",
+		"valid_to DATE",
+		"prev_float_shares DOUBLE",
+		"prev_total_shares DOUBLE",
+		"float_shares DOUBLE",
+		"total_shares DOUBLE",
+	},
+	PrimaryKey: []string{"code", "valid_from"},
+}
+
+// ImportCapital 重建股本结构表，data 需按代码和日期排序且同一天只有一条，
+// 每条记录的 valid_to 为同一代码下一次变动的日期
+func ImportCapital(db *sql.DB, data []model.CapitalData) error {
+	// 与股本变迁表一样每次都重新建表
+	if err := DropTable(db, CapitalSchema); err != nil {
+		return fmt.Errorf("failed to drop table: %w", err)
+	}
+
+	if err := CreateTable(db, CapitalSchema); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	err := AppendRows(db, CapitalSchema, func(appendRow func(values ...driver.Value) error) error {
+		for i, c := range data {
+			var validTo driver.Value
+			if i+1 < len(data) && data[i+1].Code == c.Code {
+				validTo = data[i+1].Date
+			}
+			err := appendRow(c.Code, c.Date, validTo,
+				roundGbbq(c.PrevOutstanding), roundGbbq(c.PrevTotal), roundGbbq(c.Outstanding), roundGbbq(c.Total))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import capital: %w", err)
+	}
+
+	return nil
+}
@@ -1,12 +1,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"strings"
 	"time"
 
-	_ "github.com/duckdb/duckdb-go/v2"
+	"github.com/duckdb/duckdb-go/v2"
 	"github.com/jing2uo/tdx2db/model"
 )
 
@@ -24,16 +26,26 @@ func Connect(cfg model.DBConfig) (*sql.DB, error) {
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("database ping failed: %w", err)
 	}
+
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
 	return db, nil
 }
 
 type TableSchema struct {
 	Name    string
 	Columns []string
+	// PrimaryKey 为空表示表没有主键，导入时直接追加
+	PrimaryKey []string
 }
 
 func CreateTable(db *sql.DB, schema TableSchema) error {
 	columnsStr := strings.Join(schema.Columns, ", ")
+	if len(schema.PrimaryKey) > 0 {
+		columnsStr += fmt.Sprintf(", PRIMARY KEY (%s)", strings.Join(schema.PrimaryKey, ", "))
+	}
 	query := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %s (
 			%s
@@ -44,9 +56,19 @@ func CreateTable(db *sql.DB, schema TableSchema) error {
 	if err != nil {
 		return fmt.Errorf("failed to create table %s: %w", schema.Name, err)
 	}
+
 	return nil
 }
 
+// columnNames 从列定义中解析出列名（保持顺序）
+func columnNames(schema TableSchema) []string {
+	names := make([]string, 0, len(schema.Columns))
+	for _, colDef := range schema.Columns {
+		names = append(names, strings.SplitN(colDef, " ", 2)[0])
+	}
+	return names
+}
+
 func DropTable(db *sql.DB, schema TableSchema) error {
 	query := fmt.Sprintf(`
 		DROP TABLE IF EXISTS %s
@@ -59,50 +81,89 @@ func DropTable(db *sql.DB, schema TableSchema) error {
 	return nil
 }
 
-// ImportCSV 使用TableSchema导入CSV
-func ImportCSV(db *sql.DB, schema TableSchema, csvPath string) error {
-	// 解析列名（保持顺序）
-	var columnNames []string
-	columns := make(map[string]string)
-	for _, colDef := range schema.Columns {
-		parts := strings.SplitN(colDef, " ", 2)
-		if len(parts) < 2 {
-			return fmt.Errorf("invalid column definition: %s", colDef)
-		}
-		columnNames = append(columnNames, parts[0])
-		columns[parts[0]] = parts[1]
+// AppendRows 使用 DuckDB Appender 将 fill 产生的行直接写入表，
+// 每行的值需与 schema.Columns 的顺序一致。
+func AppendRows(db *sql.DB, schema TableSchema, fill func(appendRow func(values ...driver.Value) error) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
 	}
+	defer conn.Close()
 
-	// 构建列定义字符串（用于 read_csv）
-	colDefs := ""
-	for _, col := range columnNames {
-		colDefs += fmt.Sprintf("'%s': '%s', ", col, columns[col])
+	if err := appendRows(conn, "", schema.Name, fill); err != nil {
+		return fmt.Errorf("failed to append rows to %s: %w", schema.Name, err)
 	}
-	colDefs = strings.TrimSuffix(colDefs, ", ")
+	return nil
+}
 
-	// 构建 INSERT 语句的目标列列表
-	targetCols := strings.Join(columnNames, ", ")
+// UpsertRows 先将 fill 产生的行写入临时表，再按主键 INSERT OR REPLACE 到目标表，
+// 重复导入同一批数据不会产生重复行。
+func UpsertRows(db *sql.DB, schema TableSchema, fill func(appendRow func(values ...driver.Value) error) error) error {
+	if len(schema.PrimaryKey) == 0 {
+		return fmt.Errorf("table %s has no primary key", schema.Name)
+	}
 
-	// 构建 SELECT 语句，从 read_csv 中按 schema 顺序选择列
-	selectCols := strings.Join(columnNames, ", ")
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
 
-	query := fmt.Sprintf(`
-		INSERT INTO %s (%s)
-		SELECT %s
-		FROM read_csv('%s',
-			header=true,
-			columns={%s},
-			dateformat='%%Y-%%m-%%d'
-		)
-	`, schema.Name, targetCols, selectCols, csvPath, colDefs)
+	staging := schema.Name + "_staging"
+	query := fmt.Sprintf("CREATE OR REPLACE TEMP TABLE %s AS SELECT * FROM %s WITH NO DATA", staging, schema.Name)
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create staging table %s: %w", staging, err)
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS temp.%s", staging))
 
-	_, err := db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("failed to import CSV to %s: %w", schema.Name, err)
+	if err := appendRows(conn, "temp", staging, fill); err != nil {
+		return fmt.Errorf("failed to append rows to %s: %w", staging, err)
+	}
+
+	// 同一批数据内的重复键只保留一条，否则 INSERT OR REPLACE 会报错
+	query = fmt.Sprintf(`
+		INSERT OR REPLACE INTO %s
+		SELECT DISTINCT ON (%s) %s FROM temp.%s
+	`, schema.Name, strings.Join(schema.PrimaryKey, ", "), strings.Join(columnNames(schema), ", "), staging)
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to upsert rows into %s: %w", schema.Name, err)
 	}
 	return nil
 }
 
+func appendRows(conn *sql.Conn, catalog, table string, fill func(appendRow func(values ...driver.Value) error) error) error {
+	return conn.Raw(func(driverConn any) error {
+		appender, err := duckdb.NewAppender(driverConn.(driver.Conn), catalog, "", table)
+		if err != nil {
+			return fmt.Errorf("failed to create appender for %s: %w", table, err)
+		}
+
+		if err := fill(appender.AppendRow); err != nil {
+			appender.Close()
+			return err
+		}
+
+		if err := appender.Close(); err != nil {
+			return fmt.Errorf("failed to flush appender for %s: %w", table, err)
+		}
+		return nil
+	})
+}
+
+// upsertStockData 将日线或分钟记录按主键写入表，日线和分钟表的列顺序一致
+func upsertStockData(db *sql.DB, schema TableSchema, source StockDataSource) error {
+	return UpsertRows(db, schema, func(appendRow func(values ...driver.Value) error) error {
+		return source(func(s model.StockData) error {
+			return appendRow(s.Symbol, s.Open, s.High, s.Low, s.Close, s.Amount, s.Volume, s.Date)
+		})
+	})
+}
+
+// StockDataSource 将解析出的记录逐条交给 emit，用于流式导入
+type StockDataSource func(emit func(model.StockData) error) error
+
 func GetLatestDateFromTable(db *sql.DB, tableName string) (time.Time, error) {
 	var latestDate sql.NullTime
 
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+	"github.com/jing2uo/tdx2db/model"
+)
+
+// 文件输出格式
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+	FormatJSONL   = "jsonl"
+)
+
+// CopyToFile 将 query 的结果通过 COPY 写入 path，parquet 使用 zstd 压缩，jsonl 每行一条 JSON 记录
+func CopyToFile(db *sql.DB, query, path, format string) error {
+	var options string
+	switch format {
+	case FormatParquet:
+		options = "FORMAT parquet, COMPRESSION zstd"
+	case FormatJSONL:
+		options = "FORMAT json"
+	case FormatCSV:
+		options = "FORMAT csv, HEADER"
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+
+	copyQuery := fmt.Sprintf("COPY (%s) TO '%s' (%s)", query, strings.ReplaceAll(path, "'", "''"), options)
+	if _, err := db.Exec(copyQuery); err != nil {
+		return fmt.Errorf("failed to copy to %s: %w", path, err)
+	}
+	return nil
+}
+
+// WriteStockFile 将 source 产生的日线或分钟记录按 schema 的列类型写入 parquet 或 jsonl 文件，
+// 按股票和日期排序。记录先写入 scratchPath 处的临时数据库，数据量大时可以落盘，完成后删除。
+func WriteStockFile(scratchPath string, schema TableSchema, source StockDataSource, output, format string) error {
+	db, err := openScratchDB(scratchPath)
+	if err != nil {
+		return err
+	}
+	defer removeScratchDB(db, scratchPath)
+
+	// 源文件中的记录不会重复，不需要主键
+	schema.PrimaryKey = nil
+	if err := CreateTable(db, schema); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+	err = AppendRows(db, schema, func(appendRow func(values ...driver.Value) error) error {
+		return source(func(s model.StockData) error {
+			return appendRow(s.Symbol, s.Open, s.High, s.Low, s.Close, s.Amount, s.Volume, s.Date)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	names := columnNames(schema)
+	query := fmt.Sprintf("SELECT * FROM %s ORDER BY %s, %s", schema.Name, names[0], names[len(names)-1])
+	return CopyToFile(db, query, output, format)
+}
+
+// WriteGbbqFile 将股本变迁数据按 GBBQSchema 的列类型写入 parquet 或 jsonl 文件
+func WriteGbbqFile(scratchPath string, data []model.GbbqData, output, format string) error {
+	db, err := openScratchDB(scratchPath)
+	if err != nil {
+		return err
+	}
+	defer removeScratchDB(db, scratchPath)
+
+	if err := importGbbqRows(db, data); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s ORDER BY code, date, category", GBBQSchema.Name)
+	return CopyToFile(db, query, output, format)
+}
+
+func openScratchDB(path string) (*sql.DB, error) {
+	os.Remove(path)
+	db, err := sql.Open("duckdb", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scratch database: %w", err)
+	}
+	return db, nil
+}
+
+func removeScratchDB(db *sql.DB, path string) {
+	db.Close()
+	os.Remove(path)
+	os.Remove(path + ".wal")
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+)
+
+// 导出分区方式
+const (
+	PartitionByMonth  = "month"  // year=YYYY/month=M
+	PartitionByPrefix = "prefix" // prefix=sh60，取代码前 4 位
+)
+
+// ExportTable 描述可导出的表
+type ExportTable struct {
+	// Name 为导出目录名，也是 --tables 中使用的名称
+	Name       string
+	Schema     TableSchema
+	DateColumn string
+	// SymbolColumn 为空时只能按日期分区
+	SymbolColumn string
+}
+
+// ExportTables 为支持导出的表，按导出顺序排列
+var ExportTables = []ExportTable{
+	{"daily", StocksSchema, "date", "symbol"},
+	{"1min", OneMinLineSchema, "datetime", "symbol"},
+	{"5min", FiveMinLineSchema, "datetime", "symbol"},
+	{"factor", FactorSchema, "date", "symbol"},
+	{"gbbq", GBBQSchema, "date", ""},
+}
+
+// PartitionFingerprint 记录分区的行数和内容哈希，用于判断分区是否变化
+type PartitionFingerprint struct {
+	Rows int64  `json:"rows"`
+	Hash string `json:"hash"`
+}
+
+// partitionExpr 返回分区路径的 SQL 表达式，没有代码列的表按年分区
+func (t ExportTable) partitionExpr(by string) string {
+	switch {
+	case by == PartitionByPrefix && t.SymbolColumn != "":
+		return fmt.Sprintf("'prefix=' || substr(%s, 1, 4)", t.SymbolColumn)
+	case t.SymbolColumn == "":
+		return fmt.Sprintf("printf('year=%%d', year(%s))", t.DateColumn)
+	default:
+		return fmt.Sprintf("printf('year=%%d/month=%%d', year(%[1]s), month(%[1]s))", t.DateColumn)
+	}
+}
+
+// ExportTableExists 判断表是否存在
+func ExportTableExists(db *sql.DB, t ExportTable) (bool, error) {
+	return tableExists(db, t.Schema.Name)
+}
+
+// PartitionFingerprints 返回表中每个分区的路径和指纹
+func PartitionFingerprints(db *sql.DB, t ExportTable, by string) (map[string]PartitionFingerprint, error) {
+	query := fmt.Sprintf(`
+		SELECT %[1]s AS partition, COUNT(*), CAST(SUM(hash(%[2]s)) AS VARCHAR)
+		FROM %[3]s
+		GROUP BY partition
+	`, t.partitionExpr(by), strings.Join(columnNames(t.Schema), ", "), t.Schema.Name)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint %s: %w", t.Schema.Name, err)
+	}
+	defer rows.Close()
+
+	fingerprints := make(map[string]PartitionFingerprint)
+	for rows.Next() {
+		var partition string
+		var fp PartitionFingerprint
+		if err := rows.Scan(&partition, &fp.Rows, &fp.Hash); err != nil {
+			return nil, fmt.Errorf("failed to scan fingerprint: %w", err)
+		}
+		fingerprints[partition] = fp
+	}
+	return fingerprints, rows.Err()
+}
+
+// partitionColumns 返回与 partitionExpr 对应的分区列，用于 COPY 的 PARTITION_BY
+func (t ExportTable) partitionColumns(by string) (selects, names string) {
+	switch {
+	case by == PartitionByPrefix && t.SymbolColumn != "":
+		return fmt.Sprintf("substr(%s, 1, 4) AS prefix", t.SymbolColumn), "prefix"
+	case t.SymbolColumn == "":
+		return fmt.Sprintf("year(%s) AS year", t.DateColumn), "year"
+	default:
+		return fmt.Sprintf("year(%[1]s) AS year, month(%[1]s) AS month", t.DateColumn), "year, month"
+	}
+}
+
+// ExportPartitions 扫描一次表，将 partitions 中的分区按 Hive 风格写入 dir，
+// 如 dir/year=2025/month=9/ 下的 parquet 文件，分区列只出现在路径中，parquet 使用 zstd 压缩
+func ExportPartitions(db *sql.DB, t ExportTable, by string, partitions []string, dir string) error {
+	if len(partitions) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(partitions))
+	for i, p := range partitions {
+		quoted[i] = "'" + strings.ReplaceAll(p, "'", "''") + "'"
+	}
+	orderBy := t.DateColumn
+	if t.SymbolColumn != "" {
+		orderBy = t.SymbolColumn + ", " + t.DateColumn
+	}
+	selects, names := t.partitionColumns(by)
+
+	query := fmt.Sprintf(`
+		COPY (
+			SELECT *, %s FROM %s WHERE %s IN (%s) ORDER BY %s
+		) TO '%s' (FORMAT parquet, COMPRESSION zstd, PARTITION_BY (%s), FILENAME_PATTERN 'data_{i}')
+	`, selects, t.Schema.Name, t.partitionExpr(by), strings.Join(quoted, ", "), orderBy,
+		strings.ReplaceAll(dir, "'", "''"), names)
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to export %s: %w", t.Schema.Name, err)
+	}
+	return nil
+}
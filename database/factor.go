@@ -2,9 +2,14 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"math"
+	"strings"
+	"time"
 
 	_ "github.com/duckdb/duckdb-go/v2"
+	"github.com/jing2uo/tdx2db/model"
 )
 
 // 预定义表结构
@@ -20,8 +25,11 @@ var FactorSchema = TableSchema{
 	},
 }
 
-// 使用示例
-func ImportFactorCsv(db *sql.DB, csvPath string) error {
+// FactorSource 将计算出的复权因子逐条交给 emit，用于流式导入
+type FactorSource func(emit func(model.Factor) error) error
+
+// ImportFactors 重建复权因子表并写入 source 产生的因子，数值保留 4 位小数
+func ImportFactors(db *sql.DB, source FactorSource) error {
 	//每次导入都重新建表
 	if err := DropTable(db, FactorSchema); err != nil {
 		return fmt.Errorf("failed to drop table: %w", err)
@@ -32,8 +40,244 @@ func ImportFactorCsv(db *sql.DB, csvPath string) error {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
 
-	if err := ImportCSV(db, FactorSchema, csvPath); err != nil {
-		return fmt.Errorf("failed to import CSV: %w", err)
+	if err := appendFactors(db, source); err != nil {
+		return fmt.Errorf("failed to import factors: %w", err)
+	}
+	return nil
+}
+
+func appendFactors(db *sql.DB, source FactorSource) error {
+	return AppendRows(db, FactorSchema, func(appendRow func(values ...driver.Value) error) error {
+		return source(func(f model.Factor) error {
+			return appendRow(
+				f.Symbol,
+				f.Date,
+				roundFactor(f.Close),
+				roundFactor(f.PreClose),
+				roundFactor(f.QfqFactor),
+				roundFactor(f.HfqFactor),
+			)
+		})
+	})
+}
+
+func roundFactor(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+// XdxrSnapshotSchema 保存上次计算复权因子时使用的除权除息记录，用于判断哪些股票需要重算
+var XdxrSnapshotSchema = TableSchema{
+	Name: "raw_factor_xdxr_snapshot",
+	Columns: []string{
+		"date DATE",
+		"code VARCHAR",
+		"fenhong DOUBLE",
+		"peigujia DOUBLE",
+		"songzhuangu DOUBLE",
+		"peigu DOUBLE",
+	},
+}
+
+// FactorsInitialized 判断是否已有可增量更新的复权因子和除权除息快照
+func FactorsInitialized(db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM duckdb_tables()
+		WHERE table_name IN (?, ?) AND NOT temporary
+	`, FactorSchema.Name, XdxrSnapshotSchema.Name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to query factor tables: %w", err)
+	}
+	if count < 2 {
+		return false, nil
+	}
+
+	var rows int
+	if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", FactorSchema.Name)).Scan(&rows); err != nil {
+		return false, fmt.Errorf("failed to count factors: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// effectiveXdxrQuery 返回已生效的除权除息记录，即不晚于日线最新日期的记录。
+// gbbq 会提前公布除权除息日，这些记录在除权除息日的日线导入前不参与因子计算，也不写入快照，
+// 等到生效后与快照不同，对应股票才会重算。
+func effectiveXdxrQuery() string {
+	return fmt.Sprintf(`
+		SELECT date, code, fenhong, peigujia, songzhuangu, peigu FROM %s
+		WHERE date <= (SELECT MAX(date) FROM %s)
+	`, XdxrViewName, StocksSchema.Name)
+}
+
+// QueryStaleFactorSymbols 返回需要全量重算复权因子的股票：
+// 没有因子记录的、已生效的除权除息记录相比上次计算发生变化的、以及存在晚于最新因子日期的已生效事件的。
+func QueryStaleFactorSymbols(db *sql.DB) ([]string, error) {
+	query := fmt.Sprintf(`
+	WITH last AS (
+		SELECT symbol, MAX(date) AS date FROM %[1]s GROUP BY symbol
+	),
+	current_xdxr AS (%[2]s),
+	changed AS (
+		SELECT code FROM (SELECT * FROM current_xdxr EXCEPT SELECT * FROM %[3]s)
+		UNION
+		SELECT code FROM (SELECT * FROM %[3]s EXCEPT SELECT * FROM current_xdxr)
+	),
+	symbols AS (
+		SELECT DISTINCT symbol FROM %[4]s
+	)
+	SELECT s.symbol
+	FROM symbols s
+	LEFT JOIN last l ON s.symbol = l.symbol
+	WHERE l.symbol IS NULL
+		OR SUBSTR(s.symbol, 3) IN (SELECT code FROM changed)
+		OR EXISTS (
+			SELECT 1 FROM current_xdxr x
+			WHERE x.code = SUBSTR(s.symbol, 3) AND x.date > l.date
+		)
+	ORDER BY s.symbol
+	`, FactorSchema.Name, effectiveXdxrQuery(), XdxrSnapshotSchema.Name, StocksSchema.Name)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stale factor symbols: %w", err)
+	}
+	defer rows.Close()
+
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, fmt.Errorf("failed to scan symbol: %w", err)
+		}
+		symbols = append(symbols, symbol)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return symbols, nil
+}
+
+// ReplaceFactors 删除指定股票的复权因子，并写入 source 产生的新因子
+func ReplaceFactors(db *sql.DB, symbols []string, source FactorSource) error {
+	const chunkSize = 500
+	for start := 0; start < len(symbols); start += chunkSize {
+		end := min(start+chunkSize, len(symbols))
+		chunk := symbols[start:end]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")
+		args := make([]any, len(chunk))
+		for i, s := range chunk {
+			args[i] = s
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE symbol IN (%s)", FactorSchema.Name, placeholders)
+		if _, err := db.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to delete factors: %w", err)
+		}
+	}
+
+	if err := appendFactors(db, source); err != nil {
+		return fmt.Errorf("failed to import factors: %w", err)
+	}
+	return nil
+}
+
+// ExtendFactors 为没有新除权除息事件的股票追加最新日期的因子：
+// 前收盘价为上一交易日收盘价，后复权因子沿用最后一个值，前复权因子为 1。
+func ExtendFactors(db *sql.DB) (int64, error) {
+	query := fmt.Sprintf(`
+	INSERT INTO %[1]s
+	WITH last AS (
+		SELECT
+			symbol,
+			MAX(date) AS date,
+			arg_max(close, date) AS close,
+			arg_max(hfq_factor, date) AS hfq_factor
+		FROM %[1]s
+		GROUP BY symbol
+	)
+	SELECT
+		s.symbol,
+		s.date,
+		ROUND(s.close, 4) AS close,
+		ROUND(COALESCE(LAG(s.close) OVER (PARTITION BY s.symbol ORDER BY s.date), l.close), 4) AS pre_close,
+		1.0 AS qfq_factor,
+		l.hfq_factor
+	FROM %[2]s s
+	JOIN last l ON s.symbol = l.symbol AND s.date > l.date
+	`, FactorSchema.Name, StocksSchema.Name)
+
+	res, err := db.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to extend factors: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return n, nil
+}
+
+// SaveXdxrSnapshot 记录本次计算复权因子使用的已生效除权除息数据
+func SaveXdxrSnapshot(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE OR REPLACE TABLE %s AS
+	%s
+	`, XdxrSnapshotSchema.Name, effectiveXdxrQuery())
+
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to save xdxr snapshot: %w", err)
 	}
 	return nil
 }
+
+// QueryEffectiveXdxr 返回已生效的除权除息记录，按代码和日期排序，用于计算复权因子
+func QueryEffectiveXdxr(db *sql.DB) ([]model.XdxrData, error) {
+	rows, err := db.Query(effectiveXdxrQuery() + " ORDER BY code, date")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query xdxr: %w", err)
+	}
+	defer rows.Close()
+
+	var results []model.XdxrData
+	for rows.Next() {
+		var xdxr model.XdxrData
+		if err := rows.Scan(&xdxr.Date, &xdxr.Code, &xdxr.Fenhong, &xdxr.Peigujia, &xdxr.Songzhuangu, &xdxr.Peigu); err != nil {
+			return nil, fmt.Errorf("failed to scan xdxr data: %w", err)
+		}
+		results = append(results, xdxr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return results, nil
+}
+
+// QueryFactors 返回指定股票的复权因子，按日期升序，endDate 为空时不限制结束日期
+func QueryFactors(db *sql.DB, symbol string, endDate *time.Time) ([]model.Factor, error) {
+	query := fmt.Sprintf("SELECT symbol, date, close, pre_close, qfq_factor, hfq_factor FROM %s WHERE symbol = ?", FactorSchema.Name)
+	args := []any{symbol}
+	if endDate != nil {
+		query += " AND date <= ?"
+		args = append(args, *endDate)
+	}
+	query += " ORDER BY date"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query factors: %w", err)
+	}
+	defer rows.Close()
+
+	var results []model.Factor
+	for rows.Next() {
+		var f model.Factor
+		if err := rows.Scan(&f.Symbol, &f.Date, &f.Close, &f.PreClose, &f.QfqFactor, &f.HfqFactor); err != nil {
+			return nil, fmt.Errorf("failed to scan factor: %w", err)
+		}
+		results = append(results, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return results, nil
+}
@@ -2,7 +2,12 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 
 	_ "github.com/duckdb/duckdb-go/v2"
 	"github.com/jing2uo/tdx2db/model"
@@ -23,75 +28,127 @@ var GBBQSchema = TableSchema{
 
 var XdxrViewName = "v_xdxr"
 var TurnoverViewName = "v_turnover"
+var GbbqNamedViewName = "v_gbbq"
+var ShareChangeViewName = "v_share_changes"
+var SeoViewName = "v_seo"
+var SplitViewName = "v_split"
+var WarrantViewName = "v_warrants"
+
+// GbbqColumn 将股本变迁字段的含义映射为视图中的列名
+type GbbqColumn struct {
+	Field  string
+	Column string
+}
+
+// GbbqView 为从 raw_gbbq 生成的类型化视图，字段含义包含全部 Columns 的类型都会纳入
+type GbbqView struct {
+	Name    string
+	Columns []GbbqColumn
+}
+
+// GbbqViews 为按字段含义生成的视图，股本单位为万股
+var GbbqViews = []GbbqView{
+	{XdxrViewName, []GbbqColumn{{"分红", "fenhong"}, {"配股价", "peigujia"}, {"送转股", "songzhuangu"}, {"配股", "peigu"}}},
+	{ShareChangeViewName, []GbbqColumn{{"前流通盘", "prev_float_shares"}, {"前总股本", "prev_total_shares"}, {"后流通盘", "float_shares"}, {"后总股本", "total_shares"}}},
+	{SeoViewName, []GbbqColumn{{"增发价", "issue_price"}, {"增发数量", "issue_shares"}}},
+	{SplitViewName, []GbbqColumn{{"比例", "ratio"}}},
+	{WarrantViewName, []GbbqColumn{{"行权价", "strike_price"}, {"份数", "warrants"}}},
+}
+
+// CreateGbbqViews 根据股本变迁类型的字段含义创建 v_gbbq（附带类型名称）和 GbbqViews 中的视图，
+// 视图列依次为 date、code、映射出的字段、category、category_name
+func CreateGbbqViews(db *sql.DB, categories []model.GbbqCategory) error {
+	names := categoryNameExpr(categories)
 
-func CreateXdxrView(db *sql.DB) error {
 	query := fmt.Sprintf(`
 	CREATE OR REPLACE VIEW %s AS
-	SELECT
-		date,
-		code,
-		c1 as fenhong,
-		c2 as peigujia,
-		c3 as songzhuangu,
-		c4 as peigu,
-	FROM %s
-	WHERE category=1;
-	`, XdxrViewName, GBBQSchema.Name)
-
-	_, err := db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("failed to create or replace view %s: %w", XdxrViewName, err)
+	SELECT *, %s AS category_name
+	FROM %s;
+	`, GbbqNamedViewName, names, GBBQSchema.Name)
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create or replace view %s: %w", GbbqNamedViewName, err)
+	}
+
+	for _, view := range GbbqViews {
+		selects, err := gbbqViewSelects(view, categories, names)
+		if err != nil {
+			return err
+		}
+		query := fmt.Sprintf(`
+	CREATE OR REPLACE VIEW %s AS
+	%s;
+	`, view.Name, strings.Join(selects, "\n\tUNION ALL\n\t"))
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to create or replace view %s: %w", view.Name, err)
+		}
 	}
 	return nil
 }
 
-func CreateTurnoverView(db *sql.DB) error {
-	query := fmt.Sprintf(`
-    CREATE OR REPLACE VIEW %s AS
-    WITH base_cc AS (
-        SELECT
-            date,
-            code,
-            c3 AS float_shares,
-            c4 AS total_shares
-        FROM %s
-        WHERE category IN (2, 3, 5, 7, 8, 9, 10)
-    ),
-    expanded AS (
-        SELECT
-            d.date,
-            d.symbol,
-            LAST_VALUE(base_cc.float_shares IGNORE NULLS)
-                OVER (PARTITION BY d.symbol ORDER BY d.date) AS float_shares,
-            LAST_VALUE(base_cc.total_shares IGNORE NULLS)
-                OVER (PARTITION BY d.symbol ORDER BY d.date) AS total_shares
-        FROM %s d
-        LEFT JOIN base_cc
-            ON base_cc.code = SUBSTR(d.symbol, 3)
-            AND base_cc.date = d.date
-    )
-
-    SELECT
-        r.date,
-        r.symbol,
-        ROUND(r.volume / (e.float_shares * 10000), 4) AS turnover,
-        ROUND(e.float_shares * 10000 * r.close, 4) AS circ_mv,
-        ROUND(e.total_shares * 10000 * r.close, 4) AS total_mv
-    FROM %s r
-    JOIN expanded e
-        ON r.symbol = e.symbol
-        AND r.date = e.date;
-	`, TurnoverViewName, GBBQSchema.Name, StocksSchema.Name, StocksSchema.Name)
-
-	_, err := db.Exec(query)
+// gbbqViewSelects 为视图生成查询，字段位置相同的类型合并为一个 category IN (...) 查询
+func gbbqViewSelects(view GbbqView, categories []model.GbbqCategory, names string) ([]string, error) {
+	var keys []string
+	ids := make(map[string][]string)
+	for _, category := range categories {
+		columns := make([]string, 0, len(view.Columns))
+		for _, col := range view.Columns {
+			i := slices.Index(category.Fields, col.Field)
+			if i < 0 || i > 3 {
+				break
+			}
+			columns = append(columns, fmt.Sprintf("c%d AS %s", i+1, col.Column))
+		}
+		if len(columns) < len(view.Columns) {
+			continue
+		}
+		key := strings.Join(columns, ", ")
+		if _, ok := ids[key]; !ok {
+			keys = append(keys, key)
+		}
+		ids[key] = append(ids[key], strconv.Itoa(category.ID))
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no GBBQ category has all fields of view %s", view.Name)
+	}
+
+	selects := make([]string, 0, len(keys))
+	for _, key := range keys {
+		selects = append(selects, fmt.Sprintf(
+			"SELECT date, code, %s, category, %s AS category_name FROM %s WHERE category IN (%s)",
+			key, names, GBBQSchema.Name, strings.Join(ids[key], ", ")))
+	}
+	return selects, nil
+}
+
+// categoryNameExpr 返回将 category 编号转换为名称的 CASE 表达式
+func categoryNameExpr(categories []model.GbbqCategory) string {
+	var b strings.Builder
+	b.WriteString("CASE category")
+	for _, category := range categories {
+		fmt.Fprintf(&b, " WHEN %d THEN '%s'", category.ID, strings.ReplaceAll(category.Name, "'", "''"))
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+// CapitalExtractor 从股本变迁记录中提取股本结构变化，结果需满足 ImportCapital 的要求
+type CapitalExtractor func(data []model.GbbqData) ([]model.CapitalData, error)
+
+// ImportGbbq 重建股本变迁表并写入解析出的记录，数值保留 6 位小数。
+// 同时用 extract 重建股本结构表，有股本变迁表时总有对应的 raw_capital。
+func ImportGbbq(db *sql.DB, data []model.GbbqData, extract CapitalExtractor) error {
+	if err := importGbbqRows(db, data); err != nil {
+		return err
+	}
+
+	capital, err := extract(data)
 	if err != nil {
-		// 提供更详细的错误信息
-		return fmt.Errorf("failed to create or replace view %s: %w", TurnoverViewName, err)
+		return fmt.Errorf("failed to extract capital history: %w", err)
 	}
-	return nil
+	return ImportCapital(db, capital)
 }
 
-func ImportGbbqCsv(db *sql.DB, csvPath string) error {
+func importGbbqRows(db *sql.DB, data []model.GbbqData) error {
 	//每次导入都重新建表
 	if err := DropTable(db, GBBQSchema); err != nil {
 		return fmt.Errorf("failed to drop table: %w", err)
@@ -101,31 +158,23 @@ func ImportGbbqCsv(db *sql.DB, csvPath string) error {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
 
-	if err := ImportCSV(db, GBBQSchema, csvPath); err != nil {
-		return fmt.Errorf("failed to import CSV: %w", err)
+	err := AppendRows(db, GBBQSchema, func(appendRow func(values ...driver.Value) error) error {
+		for _, g := range data {
+			err := appendRow(int32(g.Category), g.Date, g.Code, roundGbbq(g.C1), roundGbbq(g.C2), roundGbbq(g.C3), roundGbbq(g.C4))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import gbbq: %w", err)
 	}
 
 	return nil
 }
 
-func QueryAllXdxr(db *sql.DB) ([]model.XdxrData, error) {
-	query := fmt.Sprintf("SELECT * FROM %s ORDER BY code, date", XdxrViewName)
-
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query xdxr: %w", err)
-	}
-	defer rows.Close()
-
-	var results []model.XdxrData
-	for rows.Next() {
-		var xdxr model.XdxrData
-		err := rows.Scan(&xdxr.Date, &xdxr.Code, &xdxr.Fenhong, &xdxr.Peigujia, &xdxr.Songzhuangu, &xdxr.Peigu)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan xdxr data: %w", err)
-		}
-		results = append(results, xdxr)
-	}
-
-	return results, nil
+// roundGbbq 去掉 float32 转换带来的尾差
+func roundGbbq(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
 }
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+)
+
+var MigrationSchema = TableSchema{
+	Name: "schema_migrations",
+	Columns: []string{
+		"version INTEGER",
+		"name VARCHAR",
+		"applied_at TIMESTAMP",
+	},
+	PrimaryKey: []string{"version"},
+}
+
+// Migration 描述一次数据库结构升级，Version 从 1 开始连续递增。
+// Up 在事务中执行，需要兼容表尚不存在的新数据库。
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// 已发布的迁移只能追加，不能修改或删除
+var migrations = []Migration{
+	{Version: 1, Name: "add primary keys to stock tables", Up: addStockPrimaryKeys},
+}
+
+// LatestSchemaVersion 返回当前程序支持的最新数据库版本
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate 按版本顺序执行尚未应用的迁移
+func Migrate(db *sql.DB) error {
+	if err := CreateTable(db, MigrationSchema); err != nil {
+		return err
+	}
+
+	current, err := GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than supported version %d, please upgrade tdx2db", current, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		fmt.Printf("🔧 数据库结构升级至 v%d: %s\n", m.Version, m.Name)
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetSchemaVersion 返回数据库已应用的最新迁移版本，未应用任何迁移时为 0
+func GetSchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	query := fmt.Sprintf("SELECT MAX(version) FROM %s", MigrationSchema.Name)
+	if err := db.QueryRow(query).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to query schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s VALUES (?, ?, now())", MigrationSchema.Name)
+	if _, err := tx.Exec(query, m.Version, m.Name); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// rowQuerier 为 *sql.DB 和 *sql.Tx 共有的查询方法
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func tableExists(q rowQuerier, name string) (bool, error) {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM duckdb_tables() WHERE table_name = ? AND NOT temporary", name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to query table %s: %w", name, err)
+	}
+	return count > 0, nil
+}
+
+// --- 迁移 ---
+
+// addStockPrimaryKeys 为旧版本创建的无主键日线、分钟表补上主键，重复的行只保留一条
+func addStockPrimaryKeys(tx *sql.Tx) error {
+	for _, schema := range []TableSchema{StocksSchema, OneMinLineSchema, FiveMinLineSchema} {
+		exists, err := tableExists(tx, schema.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		var count int
+		err = tx.QueryRow(`
+			SELECT COUNT(*) FROM duckdb_constraints()
+			WHERE table_name = ? AND constraint_type = 'PRIMARY KEY'
+		`, schema.Name).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to query constraints: %w", err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		oldName := schema.Name + "_old"
+		keys := strings.Join(schema.PrimaryKey, ", ")
+		queries := []string{
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", schema.Name, oldName),
+			fmt.Sprintf("CREATE TABLE %s (%s, PRIMARY KEY (%s))", schema.Name, strings.Join(schema.Columns, ", "), keys),
+			fmt.Sprintf("INSERT INTO %s SELECT DISTINCT ON (%s) %s FROM %s", schema.Name, keys, strings.Join(columnNames(schema), ", "), oldName),
+			fmt.Sprintf("DROP TABLE %s", oldName),
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(query); err != nil {
+				return fmt.Errorf("failed to rebuild %s: %w", schema.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -18,36 +18,99 @@ var minLineColumns = []string{
 	"datetime TIMESTAMP",
 }
 
+var minLinePrimaryKey = []string{"symbol", "datetime"}
+
+var Qfq1MinViewName = "v_qfq_1min"
+var Hfq1MinViewName = "v_hfq_1min"
+var Qfq5MinViewName = "v_qfq_5min"
+var Hfq5MinViewName = "v_hfq_5min"
+
 var OneMinLineSchema = TableSchema{
-	Name:    "raw_stocks_1min",
-	Columns: minLineColumns,
+	Name:       "raw_stocks_1min",
+	Columns:    minLineColumns,
+	PrimaryKey: minLinePrimaryKey,
 }
 
 var FiveMinLineSchema = TableSchema{
-	Name:    "raw_stocks_5min",
-	Columns: minLineColumns,
+	Name:       "raw_stocks_5min",
+	Columns:    minLineColumns,
+	PrimaryKey: minLinePrimaryKey,
 }
 
-func Import1MinLineCsv(db *sql.DB, csvPath string) error {
+// Import1MinLine 将 source 产生的 1 分钟记录按 (symbol, datetime) 写入分钟表，已存在的行会被替换
+func Import1MinLine(db *sql.DB, source StockDataSource) error {
 	if err := CreateTable(db, OneMinLineSchema); err != nil {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
 
-	if err := ImportCSV(db, OneMinLineSchema, csvPath); err != nil {
-		return fmt.Errorf("failed to import CSV: %w", err)
+	if err := upsertStockData(db, OneMinLineSchema, source); err != nil {
+		return fmt.Errorf("failed to import minute line: %w", err)
 	}
 
 	return nil
 }
 
-func Import5MinLineCsv(db *sql.DB, csvPath string) error {
+// Import5MinLine 将 source 产生的 5 分钟记录按 (symbol, datetime) 写入分钟表，已存在的行会被替换
+func Import5MinLine(db *sql.DB, source StockDataSource) error {
 	if err := CreateTable(db, FiveMinLineSchema); err != nil {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
 
-	if err := ImportCSV(db, FiveMinLineSchema, csvPath); err != nil {
-		return fmt.Errorf("failed to import CSV: %w", err)
+	if err := upsertStockData(db, FiveMinLineSchema, source); err != nil {
+		return fmt.Errorf("failed to import minute line: %w", err)
+	}
+
+	return nil
+}
+
+// CreateMinLineFqViews 为已存在的分钟表创建前复权和后复权视图，
+// 分钟线使用所在交易日的复权因子，取整规则与日线视图相同
+func CreateMinLineFqViews(db *sql.DB) error {
+	views := []struct {
+		schema   TableSchema
+		qfq, hfq string
+	}{
+		{OneMinLineSchema, Qfq1MinViewName, Hfq1MinViewName},
+		{FiveMinLineSchema, Qfq5MinViewName, Hfq5MinViewName},
 	}
 
+	for _, v := range views {
+		exists, err := tableExists(db, v.schema.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := createMinLineFqView(db, v.schema, v.qfq, "qfq_factor"); err != nil {
+			return err
+		}
+		if err := createMinLineFqView(db, v.schema, v.hfq, "hfq_factor"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createMinLineFqView(db *sql.DB, schema TableSchema, viewName, factorColumn string) error {
+	query := fmt.Sprintf(`
+	CREATE OR REPLACE VIEW %[1]s AS
+	SELECT
+		m.symbol,
+		m.datetime,
+		m.volume,
+		m.amount,
+		ROUND(m.open  * f.%[4]s, 2) AS open,
+		ROUND(m.high  * f.%[4]s, 2) AS high,
+		ROUND(m.low   * f.%[4]s, 2) AS low,
+		ROUND(m.close * f.%[4]s, 2) AS close,
+	FROM %[2]s m
+	JOIN %[3]s f ON m.symbol = f.symbol AND CAST(m.datetime AS DATE) = f.date;
+	`, viewName, schema.Name, FactorSchema.Name, factorColumn)
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to create or replace view %s: %w", viewName, err)
+	}
 	return nil
 }
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+)
+
+// SettingsSchema 保存需要在多次运行之间保持一致的选项，如 init 时选择的导入范围
+var SettingsSchema = TableSchema{
+	Name: "raw_settings",
+	Columns: []string{
+		"key VARCHAR",
+		"value VARCHAR",
+		"updated_at TIMESTAMP",
+	},
+	PrimaryKey: []string{"key"},
+}
+
+// 设置项
+const (
+	SettingUniverse = "universe"
+)
+
+// GetSetting 读取设置项，不存在时 ok 为 false
+func GetSetting(db *sql.DB, key string) (value string, ok bool, err error) {
+	exists, err := tableExists(db, SettingsSchema.Name)
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	query := fmt.Sprintf("SELECT value FROM %s WHERE key = ?", SettingsSchema.Name)
+	err = db.QueryRow(query, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read setting %s: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// SetSetting 保存设置项，已存在时覆盖
+func SetSetting(db *sql.DB, key, value string) error {
+	if err := CreateTable(db, SettingsSchema); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	query := fmt.Sprintf("INSERT OR REPLACE INTO %s VALUES (?, ?, ?)", SettingsSchema.Name)
+	if _, err := db.Exec(query, key, value, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to save setting %s: %w", key, err)
+	}
+	return nil
+}
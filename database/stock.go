@@ -21,6 +21,7 @@ var StocksSchema = TableSchema{
 		"volume BIGINT",
 		"date DATE",
 	},
+	PrimaryKey: []string{"symbol", "date"},
 }
 
 var QfqViewName = "v_qfq_stocks"
@@ -76,13 +77,52 @@ func CreateHfqView(db *sql.DB) error {
 	return nil
 }
 
-func ImportStockCsv(db *sql.DB, csvPath string) error {
+// QfqAsOfMacroName 为按任意基准日前复权的表宏，用法：SELECT * FROM qfq_asof('sh600000', DATE '2024-06-28')
+var QfqAsOfMacroName = "qfq_asof"
+
+// CreateQfqAsOfMacro 创建表宏 qfq_asof(sym, anchor)：前复权因子为 qfq_factor 除以基准日（或之前最近的交易日）的 qfq_factor，
+// 基准日的价格与原始价格相同，不会用到基准日之后的除权除息，停牌期间的除权除息已包含在存储的因子中。
+// 结果只包含基准日及之前的日线，计算方式与 tdx.QfqFactorAsOf 相同。
+func CreateQfqAsOfMacro(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE OR REPLACE MACRO %[1]s(sym, anchor) AS TABLE
+	WITH a AS (
+		SELECT
+			symbol,
+			date,
+			qfq_factor / NULLIF(arg_max(qfq_factor, date) OVER (), 0) AS qfq_factor
+		FROM %[2]s
+		WHERE symbol = sym AND date <= anchor
+	)
+	SELECT
+		s.symbol,
+		s.date,
+		s.volume,
+		s.amount,
+		ROUND(s.open  * a.qfq_factor, 2) AS open,
+		ROUND(s.high  * a.qfq_factor, 2) AS high,
+		ROUND(s.low   * a.qfq_factor, 2) AS low,
+		ROUND(s.close * a.qfq_factor, 2) AS close,
+		a.qfq_factor,
+	FROM v_stocks_daily s
+	JOIN a ON s.symbol = a.symbol AND s.date = a.date
+	ORDER BY s.date;
+	`, QfqAsOfMacroName, FactorSchema.Name)
+
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create or replace macro %s: %w", QfqAsOfMacroName, err)
+	}
+	return nil
+}
+
+// ImportStocks 将 source 产生的日线记录按 (symbol, date) 写入日线表，已存在的行会被替换
+func ImportStocks(db *sql.DB, source StockDataSource) error {
 	if err := CreateTable(db, StocksSchema); err != nil {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
 
-	if err := ImportCSV(db, StocksSchema, csvPath); err != nil {
-		return fmt.Errorf("failed to import CSV: %w", err)
+	if err := upsertStockData(db, StocksSchema, source); err != nil {
+		return fmt.Errorf("failed to import stocks: %w", err)
 	}
 
 	return nil
@@ -161,6 +201,26 @@ func QueryAllSymbols(db *sql.DB) ([]string, error) {
 	return symbols, nil
 }
 
+// QuerySymbolsBetween 返回在 [from, to] 范围内有日线数据的股票
+func QuerySymbolsBetween(db *sql.DB, from, to time.Time) ([]string, error) {
+	query := fmt.Sprintf("SELECT DISTINCT symbol FROM %s WHERE date BETWEEN ? AND ?", StocksSchema.Name)
+	rows, err := db.Query(query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query symbols: %w", err)
+	}
+	defer rows.Close()
+
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, fmt.Errorf("failed to scan symbol: %w", err)
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols, rows.Err()
+}
+
 func GetStockTableLatestDate(db *sql.DB) (time.Time, error) {
 	date, err := GetLatestDateFromTable(db, StocksSchema.Name)
 	if err != nil {
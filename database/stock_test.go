@@ -0,0 +1,102 @@
+package database
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jing2uo/tdx2db/model"
+)
+
+func TestQfqAsOfMacroSuspendedExDate(t *testing.T) {
+	db, err := Connect(model.DBConfig{Path: ""})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer db.Close()
+
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	// 2024-01-04 停牌，当天 10 送 10 除权，因子与 tdx.CalculateFqFactor 的结果相同：
+	// 除权发生在非交易日，复牌日的 pre_close 仍为停牌前的收盘价
+	stocks := []model.StockData{
+		{Symbol: "sh600000", Date: day("2024-01-02"), Open: 10, High: 10, Low: 10, Close: 10},
+		{Symbol: "sh600000", Date: day("2024-01-03"), Open: 10, High: 10, Low: 10, Close: 10},
+		{Symbol: "sh600000", Date: day("2024-01-05"), Open: 5, High: 5, Low: 5, Close: 5},
+		{Symbol: "sh600000", Date: day("2024-01-08"), Open: 5, High: 5, Low: 5, Close: 5},
+	}
+	factors := []model.Factor{
+		{Symbol: "sh600000", Date: day("2024-01-02"), Close: 10, PreClose: 10, QfqFactor: 0.5, HfqFactor: 1},
+		{Symbol: "sh600000", Date: day("2024-01-03"), Close: 10, PreClose: 10, QfqFactor: 0.5, HfqFactor: 1},
+		{Symbol: "sh600000", Date: day("2024-01-05"), Close: 5, PreClose: 10, QfqFactor: 1, HfqFactor: 2},
+		{Symbol: "sh600000", Date: day("2024-01-08"), Close: 5, PreClose: 5, QfqFactor: 1, HfqFactor: 2},
+	}
+
+	err = ImportStocks(db, func(emit func(model.StockData) error) error {
+		for _, s := range stocks {
+			if err := emit(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ImportStocks: %v", err)
+	}
+	err = ImportFactors(db, func(emit func(model.Factor) error) error {
+		for _, f := range factors {
+			if err := emit(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ImportFactors: %v", err)
+	}
+	if err := CreateDailyStockViews(db); err != nil {
+		t.Fatalf("CreateDailyStockViews: %v", err)
+	}
+	if err := CreateQfqAsOfMacro(db); err != nil {
+		t.Fatalf("CreateQfqAsOfMacro: %v", err)
+	}
+
+	tests := []struct {
+		anchor string
+		want   map[string]float64
+	}{
+		{"2024-01-08", map[string]float64{"2024-01-02": 5, "2024-01-03": 5, "2024-01-05": 5, "2024-01-08": 5}},
+		{"2024-01-06", map[string]float64{"2024-01-02": 5, "2024-01-03": 5, "2024-01-05": 5}},
+		{"2024-01-03", map[string]float64{"2024-01-02": 10, "2024-01-03": 10}},
+	}
+	for _, tt := range tests {
+		rows, err := db.Query("SELECT date, close FROM qfq_asof('sh600000', ?::DATE)", tt.anchor)
+		if err != nil {
+			t.Fatalf("anchor %s: %v", tt.anchor, err)
+		}
+		got := make(map[string]float64)
+		for rows.Next() {
+			var d time.Time
+			var close float64
+			if err := rows.Scan(&d, &close); err != nil {
+				t.Fatalf("anchor %s: %v", tt.anchor, err)
+			}
+			got[d.Format("2006-01-02")] = close
+		}
+		rows.Close()
+
+		if len(got) != len(tt.want) {
+			t.Errorf("anchor %s: got %d rows, want %d", tt.anchor, len(got), len(tt.want))
+		}
+		for d, want := range tt.want {
+			if math.Abs(got[d]-want) > 1e-9 {
+				t.Errorf("anchor %s, %s: close %v, want %v", tt.anchor, d, got[d], want)
+			}
+		}
+	}
+}
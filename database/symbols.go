@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+)
+
+// SymbolsSchema 代码维表，每个代码一行，导入日线后刷新
+var SymbolsSchema = TableSchema{
+	Name: "raw_symbols",
+	Columns: []string{
+		"symbol VARCHAR",
+		"exchange VARCHAR",
+		"board VARCHAR",
+		"type VARCHAR",
+		"first_date DATE",
+		"last_date DATE",
+		"active BOOLEAN",
+	},
+	PrimaryKey: []string{"symbol"},
+}
+
+// ActiveTradeDays 最近这么多个交易日内有日线的代码视为 active，长期停牌或已退市的代码不是
+const ActiveTradeDays = 20
+
+// SymbolTypeViews 为按品种类型筛选日线的视图，键为 raw_symbols.type
+var SymbolTypeViews = map[string]string{
+	"stock": "v_stocks_only",
+	"index": "v_indices_only",
+	"block": "v_blocks_only",
+	"etf":   "v_etf_only",
+	"bond":  "v_bonds_only",
+}
+
+// SymbolClassifier 根据代码返回交易所、板块和品种类型，板块为空时写入 NULL
+type SymbolClassifier func(symbol string) (exchange, board, kind string)
+
+// RefreshSymbols 根据日线表重建代码维表：首末交易日取自日线数据，分类由 classify 给出，
+// 并更新按品种类型筛选的视图
+func RefreshSymbols(db *sql.DB, classify SymbolClassifier) error {
+	if err := CreateTable(db, SymbolsSchema); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	activeSince, err := activeSinceDate(db)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT symbol, MIN(date), MAX(date) FROM %s GROUP BY symbol", StocksSchema.Name)
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to query symbol date ranges: %w", err)
+	}
+	defer rows.Close()
+
+	err = UpsertRows(db, SymbolsSchema, func(appendRow func(values ...driver.Value) error) error {
+		for rows.Next() {
+			var symbol string
+			var first, last time.Time
+			if err := rows.Scan(&symbol, &first, &last); err != nil {
+				return fmt.Errorf("failed to scan symbol date range: %w", err)
+			}
+			exchange, board, kind := classify(symbol)
+			var boardValue driver.Value
+			if board != "" {
+				boardValue = board
+			}
+			if err := appendRow(symbol, exchange, boardValue, kind, first, last, !last.Before(activeSince)); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh symbols: %w", err)
+	}
+
+	return createSymbolTypeViews(db)
+}
+
+// activeSinceDate 返回日线表中倒数第 ActiveTradeDays 个交易日，数据不足时返回最早的交易日
+func activeSinceDate(db *sql.DB) (time.Time, error) {
+	query := fmt.Sprintf(`
+		SELECT MIN(date) FROM (
+			SELECT DISTINCT date FROM %s ORDER BY date DESC LIMIT %d
+		)
+	`, StocksSchema.Name, ActiveTradeDays)
+
+	var since sql.NullTime
+	if err := db.QueryRow(query).Scan(&since); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query recent trading days: %w", err)
+	}
+	return since.Time, nil
+}
+
+func createSymbolTypeViews(db *sql.DB) error {
+	for kind, view := range SymbolTypeViews {
+		query := fmt.Sprintf(`
+		CREATE OR REPLACE VIEW %s AS
+		SELECT d.*
+		FROM %s d
+		WHERE d.symbol IN (SELECT symbol FROM %s WHERE type = '%s');
+		`, view, StocksSchema.Name, SymbolsSchema.Name, kind)
+
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to create or replace view %s: %w", view, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+)
+
+// TurnoverSchema 换手率和市值，由日线和股本结构计算，cron 增量维护
+var TurnoverSchema = TableSchema{
+	Name: "raw_turnover",
+	Columns: []string{
+		"date DATE",
+		"symbol VARCHAR",
+		"turnover DOUBLE",
+		"circ_mv DOUBLE",
+		"total_mv DOUBLE",
+	},
+	PrimaryKey: []string{"symbol", "date"},
+}
+
+// CapitalSnapshotSchema 保存上次计算换手率时使用的股本结构，用于判断哪些股票需要重算
+var CapitalSnapshotSchema = TableSchema{
+	Name:    "raw_turnover_capital_snapshot",
+	Columns: CapitalSchema.Columns,
+}
+
+// TurnoverChanges 为一次增量更新的结果
+type TurnoverChanges struct {
+	// Recomputed 为股本结构变化、全部日期重新计算的代码数
+	Recomputed int
+	// Written 为写入的行数，包括新日期、回补的日期和重算代码的全部日期
+	Written int64
+}
+
+// turnoverQuery 返回按交易日所在的股本区间计算换手率和市值的查询，没有股本记录的日期为 NULL
+func turnoverQuery(where string) string {
+	return fmt.Sprintf(`
+    SELECT
+        r.date,
+        r.symbol,
+        ROUND(r.volume / (c.float_shares * 10000), 4) AS turnover,
+        ROUND(c.float_shares * 10000 * r.close, 4) AS circ_mv,
+        ROUND(c.total_shares * 10000 * r.close, 4) AS total_mv
+    FROM %s r
+    LEFT JOIN %s c
+        ON c.code = SUBSTR(r.symbol, 3)
+        AND r.date >= c.valid_from
+        AND (c.valid_to IS NULL OR r.date < c.valid_to)
+    WHERE %s
+	`, StocksSchema.Name, CapitalSchema.Name, where)
+}
+
+// UpdateTurnover 增量更新换手率表：股本结构与上次计算相比有变化的代码全部重算，
+// 其余代码只补上表中还没有的日期。没有快照时全量计算，股本结构表不存在时跳过。
+func UpdateTurnover(db *sql.DB) (TurnoverChanges, error) {
+	var changes TurnoverChanges
+	exists, err := tableExists(db, CapitalSchema.Name)
+	if err != nil || !exists {
+		return changes, err
+	}
+	if err := CreateTable(db, TurnoverSchema); err != nil {
+		return changes, fmt.Errorf("failed to create table: %w", err)
+	}
+	hasSnapshot, err := tableExists(db, CapitalSnapshotSchema.Name)
+	if err != nil {
+		return changes, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return changes, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if hasSnapshot {
+		changed := fmt.Sprintf(`
+			SELECT code FROM (SELECT * FROM %[1]s EXCEPT SELECT * FROM %[2]s)
+			UNION
+			SELECT code FROM (SELECT * FROM %[2]s EXCEPT SELECT * FROM %[1]s)
+		`, CapitalSchema.Name, CapitalSnapshotSchema.Name)
+		if err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM (%s)", changed)).Scan(&changes.Recomputed); err != nil {
+			return changes, fmt.Errorf("failed to query changed capital: %w", err)
+		}
+		if changes.Recomputed > 0 {
+			query := fmt.Sprintf("DELETE FROM %s WHERE SUBSTR(symbol, 3) IN (%s)", TurnoverSchema.Name, changed)
+			if _, err := tx.Exec(query); err != nil {
+				return changes, fmt.Errorf("failed to delete stale turnover: %w", err)
+			}
+		}
+	} else {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", TurnoverSchema.Name)); err != nil {
+			return changes, fmt.Errorf("failed to clear turnover: %w", err)
+		}
+	}
+
+	// 表中没有的 (symbol, date) 都需要计算，包括新日期、回补的日期和刚删除的代码
+	missing := fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM %s t WHERE t.symbol = r.symbol AND t.date = r.date
+	)`, TurnoverSchema.Name)
+	query := fmt.Sprintf("INSERT INTO %s %s", TurnoverSchema.Name, turnoverQuery(missing))
+	res, err := tx.Exec(query)
+	if err != nil {
+		return changes, fmt.Errorf("failed to extend turnover: %w", err)
+	}
+	if changes.Written, err = res.RowsAffected(); err != nil {
+		return changes, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	query = fmt.Sprintf("CREATE OR REPLACE TABLE %s AS SELECT * FROM %s", CapitalSnapshotSchema.Name, CapitalSchema.Name)
+	if _, err := tx.Exec(query); err != nil {
+		return changes, fmt.Errorf("failed to save capital snapshot: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return changes, fmt.Errorf("failed to commit turnover: %w", err)
+	}
+	return changes, nil
+}
+
+// InvalidateTurnover 删除 [from, to] 范围内已计算的换手率，日线被重新导入后由 UpdateTurnover 重算
+func InvalidateTurnover(db *sql.DB, from, to time.Time) error {
+	exists, err := tableExists(db, TurnoverSchema.Name)
+	if err != nil || !exists {
+		return err
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE date BETWEEN ? AND ?", TurnoverSchema.Name)
+	if _, err := db.Exec(query, from, to); err != nil {
+		return fmt.Errorf("failed to invalidate turnover: %w", err)
+	}
+	return nil
+}
+
+// CreateTurnoverView 创建换手率和市值视图，直接读取 raw_turnover
+func CreateTurnoverView(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE OR REPLACE VIEW %s AS
+	SELECT date, symbol, turnover, circ_mv, total_mv FROM %s;
+	`, TurnoverViewName, TurnoverSchema.Name)
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to create or replace view %s: %w", TurnoverViewName, err)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/duckdb/duckdb-go/v2"
+)
+
+// 一致性检查项
+const (
+	CheckMissingDates      = "missing_dates"
+	CheckSuspendedDates    = "suspended_dates"
+	CheckDuplicateKeys     = "duplicate_keys"
+	CheckMinuteBarCount    = "minute_bar_count"
+	CheckAggregateMismatch = "aggregate_mismatch"
+	CheckOHLCInvariants    = "ohlc_invariants"
+	CheckMissingFactors    = "missing_factors"
+)
+
+// VerifyCheck 为一项一致性检查，Query 返回 symbol、日期和说明三列，均为字符串。
+// Info 为 true 的检查只列出供核对的记录（如停牌），不算作问题
+type VerifyCheck struct {
+	Name  string
+	Table string
+	Query string
+	Info  bool
+}
+
+// VerifyIssue 为检查发现的一条问题
+type VerifyIssue struct {
+	Symbol string `json:"symbol"`
+	Date   string `json:"date"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// VerifyChecks 返回数据库中已有的表对应的检查，日线表不存在时返回错误。
+// rule 用于找出全市场都没有日线的开市日，只采用依据为 CalendarSSE 的推算结果
+func VerifyChecks(db *sql.DB, rule TradeDayRule) ([]VerifyCheck, error) {
+	exists, err := tableExists(db, StocksSchema.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("table %s does not exist, run init first", StocksSchema.Name)
+	}
+
+	openDays, err := expectedOpenDays(db, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	daily := StocksSchema.Name
+	checks := []VerifyCheck{
+		{Name: CheckMissingDates, Table: daily, Query: missingMarketDatesQuery(openDays)},
+		{Name: CheckSuspendedDates, Table: daily, Query: suspendedDatesQuery(), Info: true},
+		{Name: CheckDuplicateKeys, Table: daily, Query: duplicateKeysQuery(daily, "date", "%Y-%m-%d")},
+		{Name: CheckOHLCInvariants, Table: daily, Query: ohlcInvariantsQuery(daily, "date", "%Y-%m-%d")},
+	}
+
+	for _, schema := range []TableSchema{OneMinLineSchema, FiveMinLineSchema} {
+		exists, err := tableExists(db, schema.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		name := schema.Name
+		checks = append(checks,
+			VerifyCheck{Name: CheckMissingDates, Table: name, Query: missingMinuteDatesQuery(name)},
+			VerifyCheck{Name: CheckMinuteBarCount, Table: name, Query: minuteBarCountQuery(name)},
+			VerifyCheck{Name: CheckDuplicateKeys, Table: name, Query: duplicateKeysQuery(name, "datetime", "%Y-%m-%d %H:%M")},
+			VerifyCheck{Name: CheckAggregateMismatch, Table: name, Query: aggregateMismatchQuery(name)},
+			VerifyCheck{Name: CheckOHLCInvariants, Table: name, Query: ohlcInvariantsQuery(name, "datetime", "%Y-%m-%d %H:%M")},
+		)
+	}
+
+	exists, err = tableExists(db, FactorSchema.Name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		checks = append(checks, VerifyCheck{Name: CheckMissingFactors, Table: FactorSchema.Name, Query: missingFactorsQuery()})
+	}
+
+	return checks, nil
+}
+
+// RunVerifyCheck 执行检查，返回问题总数和按股票、日期排序的前 limit 条问题，limit 为 0 时返回全部
+func RunVerifyCheck(db *sql.DB, check VerifyCheck, limit int) (int, []VerifyIssue, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM (%s) ORDER BY 1, 2", check.Query))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to run %s check on %s: %w", check.Name, check.Table, err)
+	}
+	defer rows.Close()
+
+	count := 0
+	var issues []VerifyIssue
+	for rows.Next() {
+		var issue VerifyIssue
+		if err := rows.Scan(&issue.Symbol, &issue.Date, &issue.Detail); err != nil {
+			return 0, nil, fmt.Errorf("failed to scan %s result: %w", check.Name, err)
+		}
+		if limit == 0 || count < limit {
+			issues = append(issues, issue)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating %s result: %w", check.Name, err)
+	}
+	return count, issues, nil
+}
+
+// expectedOpenDays 返回日线首尾日期之间 rule 按上交所休市安排判定为开市的日期
+func expectedOpenDays(db *sql.DB, rule TradeDayRule) ([]time.Time, error) {
+	var first, last sql.NullTime
+	query := fmt.Sprintf("SELECT MIN(date), MAX(date) FROM %s", StocksSchema.Name)
+	if err := db.QueryRow(query).Scan(&first, &last); err != nil {
+		return nil, fmt.Errorf("failed to query daily date range: %w", err)
+	}
+	if !first.Valid {
+		return nil, nil
+	}
+
+	var days []time.Time
+	for d := first.Time; !d.After(last.Time); d = d.AddDate(0, 0, 1) {
+		if open, source := rule(d); open && source == CalendarSSE {
+			days = append(days, d)
+		}
+	}
+	return days, nil
+}
+
+// missingMarketDatesQuery 开市但日线表中没有任何股票数据的日期，通常是漏下载了整天的文件
+func missingMarketDatesQuery(days []time.Time) string {
+	if len(days) == 0 {
+		return "SELECT '', '', '' WHERE false"
+	}
+	dates := make([]string, len(days))
+	for i, d := range days {
+		dates[i] = "DATE '" + d.Format("2006-01-02") + "'"
+	}
+	return fmt.Sprintf(`
+	SELECT '*', strftime(t.date, '%%Y-%%m-%%d'), 'no daily data for any symbol'
+	FROM unnest([%[2]s]) AS t(date)
+	WHERE NOT EXISTS (
+		SELECT 1 FROM %[1]s x WHERE x.date = t.date
+	)`, StocksSchema.Name, strings.Join(dates, ", "))
+}
+
+// suspendedDatesQuery 股票首尾日期之间，日线表中其他股票有数据而该股票没有的交易日。
+// 这些日期一般是停牌，单只股票漏数据时也会出现在这里，只供核对
+func suspendedDatesQuery() string {
+	return fmt.Sprintf(`
+	WITH days AS (
+		SELECT DISTINCT date FROM %[1]s
+	),
+	spans AS (
+		SELECT symbol, MIN(date) AS first, MAX(date) AS last FROM %[1]s GROUP BY symbol
+	)
+	SELECT s.symbol, strftime(d.date, '%%Y-%%m-%%d'), ''
+	FROM spans s
+	JOIN days d ON d.date BETWEEN s.first AND s.last
+	WHERE NOT EXISTS (
+		SELECT 1 FROM %[1]s x WHERE x.symbol = s.symbol AND x.date = d.date
+	)`, StocksSchema.Name)
+}
+
+// missingMinuteDatesQuery 分钟数据首尾日期之间，有日线但没有分钟数据的日期
+func missingMinuteDatesQuery(table string) string {
+	return fmt.Sprintf(`
+	WITH minute_days AS (
+		SELECT DISTINCT symbol, CAST(datetime AS DATE) AS date FROM %[1]s
+	),
+	spans AS (
+		SELECT symbol, MIN(date) AS first, MAX(date) AS last FROM minute_days GROUP BY symbol
+	)
+	SELECT d.symbol, strftime(d.date, '%%Y-%%m-%%d'), ''
+	FROM %[2]s d
+	JOIN spans s ON d.symbol = s.symbol AND d.date BETWEEN s.first AND s.last
+	WHERE NOT EXISTS (
+		SELECT 1 FROM minute_days m WHERE m.symbol = d.symbol AND m.date = d.date
+	)`, table, StocksSchema.Name)
+}
+
+// minuteBarCountQuery 分钟 K 线数量与当天多数股票不同的日期。
+// 预期数量取当天各股票 K 线数量的众数，半日市等交易时段较短的日子不会误报；
+// 股票日线的第一天（上市首日或数据起点）不检查
+func minuteBarCountQuery(table string) string {
+	return fmt.Sprintf(`
+	WITH counts AS (
+		SELECT symbol, CAST(datetime AS DATE) AS date, COUNT(*) AS bars
+		FROM %[1]s
+		GROUP BY symbol, CAST(datetime AS DATE)
+	),
+	expected AS (
+		SELECT date, mode(bars) AS bars FROM counts GROUP BY date
+	),
+	listing AS (
+		SELECT symbol, MIN(date) AS date FROM %[2]s GROUP BY symbol
+	)
+	SELECT c.symbol, strftime(c.date, '%%Y-%%m-%%d'), printf('bars: %%d, expected %%d', c.bars, e.bars)
+	FROM counts c
+	JOIN expected e ON c.date = e.date
+	WHERE c.bars <> e.bars
+		AND NOT EXISTS (
+			SELECT 1 FROM listing l WHERE l.symbol = c.symbol AND l.date = c.date
+		)`, table, StocksSchema.Name)
+}
+
+func duplicateKeysQuery(table, dateColumn, dateFormat string) string {
+	return fmt.Sprintf(`
+	SELECT symbol, strftime(%[2]s, '%[3]s'), printf('rows: %%d', COUNT(*))
+	FROM %[1]s
+	GROUP BY symbol, %[2]s
+	HAVING COUNT(*) > 1`, table, dateColumn, dateFormat)
+}
+
+// aggregateMismatchQuery 分钟数据按日汇总后与日线不一致的日期，价格允许 0.01 的误差，成交量允许 1%
+func aggregateMismatchQuery(table string) string {
+	return fmt.Sprintf(`
+	WITH agg AS (
+		SELECT
+			symbol,
+			CAST(datetime AS DATE) AS date,
+			arg_min(open, datetime) AS open,
+			MAX(high) AS high,
+			MIN(low) AS low,
+			arg_max(close, datetime) AS close,
+			SUM(volume) AS volume
+		FROM %[1]s
+		GROUP BY symbol, CAST(datetime AS DATE)
+	)
+	SELECT a.symbol, strftime(a.date, '%%Y-%%m-%%d'), concat_ws(', ',
+		CASE WHEN ABS(a.open - d.open) > 0.011 THEN printf('open %%.2f/%%.2f', a.open, d.open) END,
+		CASE WHEN ABS(a.high - d.high) > 0.011 THEN printf('high %%.2f/%%.2f', a.high, d.high) END,
+		CASE WHEN ABS(a.low - d.low) > 0.011 THEN printf('low %%.2f/%%.2f', a.low, d.low) END,
+		CASE WHEN ABS(a.close - d.close) > 0.011 THEN printf('close %%.2f/%%.2f', a.close, d.close) END,
+		CASE WHEN ABS(a.volume - d.volume) > d.volume * 0.01 THEN printf('volume %%d/%%d', a.volume, d.volume) END
+	)
+	FROM agg a
+	JOIN %[2]s d ON a.symbol = d.symbol AND a.date = d.date
+	WHERE ABS(a.open - d.open) > 0.011
+		OR ABS(a.high - d.high) > 0.011
+		OR ABS(a.low - d.low) > 0.011
+		OR ABS(a.close - d.close) > 0.011
+		OR ABS(a.volume - d.volume) > d.volume * 0.01`, table, StocksSchema.Name)
+}
+
+func ohlcInvariantsQuery(table, dateColumn, dateFormat string) string {
+	return fmt.Sprintf(`
+	SELECT symbol, strftime(%[2]s, '%[3]s'), concat_ws(', ',
+		CASE WHEN low > high THEN 'low > high' END,
+		CASE WHEN low > open THEN 'low > open' END,
+		CASE WHEN low > close THEN 'low > close' END,
+		CASE WHEN high < open THEN 'high < open' END,
+		CASE WHEN high < close THEN 'high < close' END,
+		CASE WHEN LEAST(open, high, low, close) <= 0 THEN 'price <= 0' END,
+		CASE WHEN volume < 0 THEN 'volume < 0' END
+	)
+	FROM %[1]s
+	WHERE low > high OR low > open OR low > close OR high < open OR high < close
+		OR LEAST(open, high, low, close) <= 0 OR volume < 0`, table, dateColumn, dateFormat)
+}
+
+func missingFactorsQuery() string {
+	return fmt.Sprintf(`
+	SELECT s.symbol, strftime(s.date, '%%Y-%%m-%%d'), ''
+	FROM %[1]s s
+	WHERE NOT EXISTS (
+		SELECT 1 FROM %[2]s f WHERE f.symbol = s.symbol AND f.date = s.date
+	)`, StocksSchema.Name, FactorSchema.Name)
+}
@@ -4,13 +4,19 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/jing2uo/tdx2db/cmd"
+	"github.com/jing2uo/tdx2db/tdx"
+	"github.com/jing2uo/tdx2db/utils"
 	"github.com/spf13/cobra"
 )
 
 const dbPathInfo = "DuckDB 文件路径"
 const dayFileInfo = "通达信日线 .day 文件目录"
+const universeInfo = "导入范围，可多次指定：default、stocks、bshares、indices、etf、cb、blocks、all"
 const minLineInfo = `导入分时数据（可选）
   1    导入1分钟数据
   5    导入5分钟数据
@@ -19,13 +25,48 @@ const minLineInfo = `导入分时数据（可选）
 
 func main() {
 
+	var timezone, asOf, publishTime string
+
 	var rootCmd = &cobra.Command{
 		Use:           "tdx2db",
 		Short:         "Load TDX Data to DuckDB",
 		SilenceErrors: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			name := flagOrEnv(c, "timezone", timezone, "TDX2DB_TIMEZONE")
+			if name == "" {
+				name = tdx.DefaultMarketTimezone
+			}
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				return fmt.Errorf("无效的时区 %s: %w", name, err)
+			}
+			now := time.Now()
+			if c.Flags().Changed("as-of") {
+				if now, err = cmd.ParseAsOf(asOf, loc); err != nil {
+					return err
+				}
+			}
+			cmd.SetMarketClock(loc, now)
+			return nil
+		},
 	}
 
-	var dbPath, dayFileDir, minline string
+	var dbPath, dayFileDir, minline, sourceDir string
+	var fromDate, toDate, backfillKind string
+	var symbols, prefixes, universe []string
+	var verifyJSON bool
+	var exportOut, exportPartition string
+	var exportTables []string
+	var exportFull bool
+	var verifyLimit int
+	var (
+		mirrors  []string
+		dayURL   string
+		ticURL   string
+		gbbqURL  string
+		useCache bool
+		cacheDir string
+	)
 	var (
 		m1FileDir   string
 		m5FileDir   string
@@ -33,13 +74,79 @@ func main() {
 		gbbqZipFile string
 		dayZipFile  string
 		outPutFile  string
+		format      string
 	)
 
+	// buildSource 根据 --source-dir、镜像和缓存参数创建数据源
+	buildSource := func(c *cobra.Command) (cmd.DataSource, error) {
+		if c.Flags().Changed("source-dir") {
+			if err := utils.CheckDirectory(sourceDir); err != nil {
+				return nil, err
+			}
+			return cmd.DirSource{Dir: sourceDir}, nil
+		}
+		if !c.Flags().Changed("mirror") {
+			if env := os.Getenv("TDX2DB_MIRRORS"); env != "" {
+				mirrors = strings.Split(env, ",")
+			}
+		}
+		source := cmd.NewHTTPSource(mirrors, map[cmd.DataKind]string{
+			cmd.DayKind:  flagOrEnv(c, "day-url", dayURL, "TDX2DB_DAY_URL"),
+			cmd.TicKind:  flagOrEnv(c, "tic-url", ticURL, "TDX2DB_TIC_URL"),
+			cmd.GbbqKind: flagOrEnv(c, "gbbq-url", gbbqURL, "TDX2DB_GBBQ_URL"),
+		})
+		if useCache || c.Flags().Changed("cache-dir") {
+			if cacheDir == "" {
+				dir, err := utils.DefaultDownloadCacheDir()
+				if err != nil {
+					return nil, err
+				}
+				cacheDir = dir
+			}
+			source.Cache = &utils.DownloadCache{Dir: cacheDir}
+		}
+		return source, nil
+	}
+
+	// buildFilter 根据 --symbols、--prefix、--from、--to 创建解析过滤条件
+	buildFilter := func(c *cobra.Command) (tdx.Filter, error) {
+		var filter tdx.Filter
+		list, err := cmd.ParseSymbols(symbols)
+		if err != nil {
+			return filter, err
+		}
+		filter.Symbols = list
+		for _, p := range prefixes {
+			filter.Prefixes = append(filter.Prefixes, strings.ToLower(strings.TrimSpace(p)))
+		}
+		if c.Flags().Changed("from") {
+			if filter.From, err = time.Parse("2006-01-02", fromDate); err != nil {
+				return filter, fmt.Errorf("--from 格式应为 YYYY-MM-DD（传入: %s）", fromDate)
+			}
+		}
+		if c.Flags().Changed("to") {
+			if filter.To, err = time.Parse("2006-01-02", toDate); err != nil {
+				return filter, fmt.Errorf("--to 格式应为 YYYY-MM-DD（传入: %s）", toDate)
+			}
+		}
+		return filter, nil
+	}
+
 	var initCmd = &cobra.Command{
 		Use:   "init",
 		Short: "Fully import stocks data from TDX",
 		RunE: func(c *cobra.Command, args []string) error {
-			if err := cmd.Init(dbPath, dayFileDir); err != nil {
+			filter, err := buildFilter(c)
+			if err != nil {
+				return err
+			}
+			opts := cmd.InitOptions{
+				DBPath:     dbPath,
+				DayFileDir: dayFileDir,
+				Universe:   universe,
+				Filter:     filter,
+			}
+			if err := cmd.Init(opts); err != nil {
 				return err
 			}
 			return nil
@@ -56,7 +163,96 @@ func main() {
 					return fmt.Errorf("--minline 允许 '1'、'5'、'1,5'、'5,1'（传入: %s）", minline)
 				}
 			}
-			if err := cmd.Cron(dbPath, minline); err != nil {
+			if c.Flags().Changed("publish-time") {
+				cutoff, err := cmd.ParsePublishCutoff(publishTime)
+				if err != nil {
+					return err
+				}
+				cmd.PublishCutoff = cutoff
+			}
+			opts := cmd.CronOptions{
+				DBPath:   dbPath,
+				Minline:  minline,
+				Universe: universe,
+			}
+			source, err := buildSource(c)
+			if err != nil {
+				return err
+			}
+			opts.Source = source
+			if err := cmd.Cron(opts); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	var backfillCmd = &cobra.Command{
+		Use:   "backfill",
+		Short: "Backfill daily or minute data for a date range",
+		RunE: func(c *cobra.Command, args []string) error {
+			from, err := time.Parse("2006-01-02", fromDate)
+			if err != nil {
+				return fmt.Errorf("--from 格式应为 YYYY-MM-DD（传入: %s）", fromDate)
+			}
+			to, err := time.Parse("2006-01-02", toDate)
+			if err != nil {
+				return fmt.Errorf("--to 格式应为 YYYY-MM-DD（传入: %s）", toDate)
+			}
+			kind := cmd.BackfillKind(backfillKind)
+			switch kind {
+			case cmd.BackfillDay, cmd.Backfill1Min, cmd.Backfill5Min:
+			default:
+				return fmt.Errorf("--kind 允许 'day'、'1min'、'5min'（传入: %s）", backfillKind)
+			}
+
+			source, err := buildSource(c)
+			if err != nil {
+				return err
+			}
+			opts := cmd.BackfillOptions{
+				DBPath:   dbPath,
+				Kind:     kind,
+				From:     from,
+				To:       to,
+				Universe: universe,
+				Source:   source,
+			}
+			if err := cmd.Backfill(opts); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	var exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export tables to Hive-partitioned Parquet files",
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := cmd.ExportOptions{
+				DBPath:      dbPath,
+				OutDir:      exportOut,
+				Tables:      exportTables,
+				PartitionBy: exportPartition,
+				Full:        exportFull,
+			}
+			if err := cmd.Export(opts); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	var verifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Check the database for gaps and inconsistent data",
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := cmd.VerifyOptions{
+				DBPath: dbPath,
+				JSON:   verifyJSON,
+				Limit:  verifyLimit,
+			}
+			if err := cmd.Verify(opts); err != nil {
 				return err
 			}
 			return nil
@@ -65,7 +261,7 @@ func main() {
 
 	var convertCmd = &cobra.Command{
 		Use:   "convert",
-		Short: "Convert TDX data to CSV",
+		Short: "Convert TDX data to CSV, Parquet or JSON Lines",
 		PreRunE: func(c *cobra.Command, args []string) error {
 			setFlags := 0
 			if c.Flags().Changed("dayfiledir") {
@@ -96,8 +292,15 @@ func main() {
 			return nil
 		},
 		RunE: func(c *cobra.Command, args []string) error {
+			filter, err := buildFilter(c)
+			if err != nil {
+				return err
+			}
 			opts := cmd.ConvertOptions{
 				OutputPath: outPutFile,
+				Format:     format,
+				Universe:   universe,
+				Filter:     filter,
 			}
 
 			if c.Flags().Changed("dayfiledir") {
@@ -127,14 +330,76 @@ func main() {
 		},
 	}
 
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade database schema to current version",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := cmd.Migrate(dbPath); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	addSourceFlags := func(c *cobra.Command) {
+		c.Flags().StringVar(&sourceDir, "source-dir", "", "从本地目录读取数据而不是下载（g4day/、g4tic/、gbbq.zip）")
+		c.Flags().StringSliceVar(&mirrors, "mirror", nil, "下载镜像地址，可多次指定，按顺序回退（环境变量 TDX2DB_MIRRORS，逗号分隔）")
+		c.Flags().StringVar(&dayURL, "day-url", "", "四代行情 URL 模板，支持 {mirror} {date}（环境变量 TDX2DB_DAY_URL）")
+		c.Flags().StringVar(&ticURL, "tic-url", "", "四代 TIC URL 模板，支持 {mirror} {date}（环境变量 TDX2DB_TIC_URL）")
+		c.Flags().StringVar(&gbbqURL, "gbbq-url", "", "股本变迁 URL 模板，支持 {mirror}（环境变量 TDX2DB_GBBQ_URL）")
+		c.Flags().BoolVar(&useCache, "cache", false, "启用持久化下载缓存，默认目录 $XDG_CACHE_HOME/tdx2db")
+		c.Flags().StringVar(&cacheDir, "cache-dir", "", "持久化下载缓存目录（指定后自动启用缓存）")
+	}
+
+	addFilterFlags := func(c *cobra.Command) {
+		c.Flags().StringSliceVar(&symbols, "symbols", nil, "只处理这些代码，如 sh600000,sz000001，也可以是每行一个代码的文件")
+		c.Flags().StringSliceVar(&prefixes, "prefix", nil, "只处理这些前缀的代码，如 sh60")
+		c.Flags().StringVar(&fromDate, "from", "", "只处理该日期及之后的记录 YYYY-MM-DD")
+		c.Flags().StringVar(&toDate, "to", "", "只处理该日期及之前的记录 YYYY-MM-DD")
+	}
+
+	rootCmd.PersistentFlags().StringVar(&timezone, "timezone", "", "交易所时区，交易日期按该时区计算，默认 Asia/Shanghai（环境变量 TDX2DB_TIMEZONE）")
+	rootCmd.PersistentFlags().StringVar(&asOf, "as-of", "", "固定参考时间，格式 YYYY-MM-DD 或 \"YYYY-MM-DD HH:MM\"（交易所时区），默认当前时间")
+
 	initCmd.Flags().StringVar(&dbPath, "dbpath", "", dbPathInfo)
 	initCmd.Flags().StringVar(&dayFileDir, "dayfiledir", "", dayFileInfo)
+	initCmd.Flags().StringSliceVar(&universe, "universe", nil, universeInfo+"，默认 default，保存到数据库供 cron 和 backfill 使用")
+	addFilterFlags(initCmd)
 	initCmd.MarkFlagRequired("dbpath")
 	initCmd.MarkFlagRequired("dayfiledir")
 
 	cronCmd.Flags().StringVar(&dbPath, "dbpath", "", dbPathInfo)
 	cronCmd.MarkFlagRequired("dbpath")
 	cronCmd.Flags().StringVar(&minline, "minline", "", minLineInfo)
+	cronCmd.Flags().StringSliceVar(&universe, "universe", nil, universeInfo+"，默认使用 init 保存的范围，指定时只对本次运行有效")
+	addSourceFlags(cronCmd)
+	cronCmd.Flags().StringVar(&publishTime, "publish-time", "17:00", "当日数据预计发布完成的时间（交易所时区），此前不获取当天的数据")
+
+	backfillCmd.Flags().StringVar(&dbPath, "dbpath", "", dbPathInfo)
+	backfillCmd.Flags().StringVar(&fromDate, "from", "", "开始日期 YYYY-MM-DD")
+	backfillCmd.Flags().StringVar(&toDate, "to", "", "结束日期 YYYY-MM-DD（包含）")
+	backfillCmd.Flags().StringVar(&backfillKind, "kind", "day", "回补的数据类型：day、1min、5min")
+	backfillCmd.MarkFlagRequired("dbpath")
+	backfillCmd.MarkFlagRequired("from")
+	backfillCmd.MarkFlagRequired("to")
+	backfillCmd.Flags().StringSliceVar(&universe, "universe", nil, universeInfo+"，默认使用 init 保存的范围，指定时只对本次运行有效")
+	addSourceFlags(backfillCmd)
+
+	exportCmd.Flags().StringVar(&dbPath, "dbpath", "", dbPathInfo)
+	exportCmd.Flags().StringVar(&exportOut, "out", "", "导出目录")
+	exportCmd.Flags().StringSliceVar(&exportTables, "tables", nil, "导出的表：daily、1min、5min、factor、gbbq，默认全部")
+	exportCmd.Flags().StringVar(&exportPartition, "partition-by", "month", "分区方式：month（year=/month=）或 prefix（代码前 4 位）")
+	exportCmd.Flags().BoolVar(&exportFull, "full", false, "忽略上次导出的清单，重新导出所有分区")
+	exportCmd.MarkFlagRequired("dbpath")
+	exportCmd.MarkFlagRequired("out")
+
+	verifyCmd.Flags().StringVar(&dbPath, "dbpath", "", dbPathInfo)
+	verifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "以 JSON 格式输出")
+	verifyCmd.Flags().IntVar(&verifyLimit, "limit", 20, "每项检查最多列出的问题数，0 表示全部列出")
+	verifyCmd.MarkFlagRequired("dbpath")
+
+	migrateCmd.Flags().StringVar(&dbPath, "dbpath", "", dbPathInfo)
+	migrateCmd.MarkFlagRequired("dbpath")
 
 	convertCmd.Flags().StringVar(&dayFileDir, "dayfiledir", "", dayFileInfo)
 	convertCmd.Flags().StringVar(&m1FileDir, "m1filedir", "", "通达信 1 分钟 .01 文件目录")
@@ -142,12 +407,19 @@ func main() {
 	convertCmd.Flags().StringVar(&ticZipFile, "ticzip", "", "通达信四代 TIC 压缩文件")
 	convertCmd.Flags().StringVar(&dayZipFile, "dayzip", "", "通达信四代行情压缩文件")
 	convertCmd.Flags().StringVar(&gbbqZipFile, "gbbqzip", "", "通达信股本变迁压缩文件")
-	convertCmd.Flags().StringVar(&outPutFile, "output", "", "CSV 文件输出目录")
+	convertCmd.Flags().StringVar(&outPutFile, "output", "", "文件输出目录")
+	convertCmd.Flags().StringVar(&format, "format", "csv", "输出格式：csv、parquet、jsonl")
+	convertCmd.Flags().StringSliceVar(&universe, "universe", nil, universeInfo+"，默认 all")
+	addFilterFlags(convertCmd)
 	convertCmd.MarkFlagRequired("output")
 
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(cronCmd)
+	rootCmd.AddCommand(backfillCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(convertCmd)
+	rootCmd.AddCommand(migrateCmd)
 
 	cobra.OnFinalize(func() {
 		os.RemoveAll(cmd.DataDir)
@@ -158,3 +430,11 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// flagOrEnv 返回命令行参数的值，未指定时读取环境变量
+func flagOrEnv(c *cobra.Command, name, value, env string) string {
+	if c.Flags().Changed(name) {
+		return value
+	}
+	return os.Getenv(env)
+}
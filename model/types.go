@@ -57,6 +57,13 @@ type GbbqData struct {
 	C4       float64
 }
 
+// GbbqCategory 为一种股本变迁类型，Fields 依次为 c1..c4 的含义，空字符串表示未使用
+type GbbqCategory struct {
+	ID     int
+	Name   string
+	Fields []string
+}
+
 type XdxrData struct {
 	Code        string
 	Date        time.Time
@@ -0,0 +1,80 @@
+package tdx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const holidayFile = "embed/sse_holidays.txt"
+
+// HolidayCalendar 为上交所公布的休市安排，只对已收录的年份有效
+type HolidayCalendar struct {
+	years    map[int]bool
+	holidays map[string]bool
+}
+
+// LoadSSEHolidays 读取内嵌的上交所休市安排
+func LoadSSEHolidays() (*HolidayCalendar, error) {
+	data, err := embedFS.ReadFile(holidayFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", holidayFile, err)
+	}
+	return ParseHolidays(data)
+}
+
+// ParseHolidays 解析休市安排，格式为 "year YYYY" 声明收录的年份，其后每行一个 YYYY-MM-DD 日期
+func ParseHolidays(data []byte) (*HolidayCalendar, error) {
+	cal := &HolidayCalendar{
+		years:    make(map[int]bool),
+		holidays: make(map[string]bool),
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if year, ok := strings.CutPrefix(line, "year "); ok {
+			y, err := strconv.Atoi(strings.TrimSpace(year))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid year %q", lineNo, year)
+			}
+			cal.years[y] = true
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", lineNo, line)
+		}
+		if !cal.years[date.Year()] {
+			return nil, fmt.Errorf("line %d: %s listed before \"year %d\"", lineNo, line, date.Year())
+		}
+		cal.holidays[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cal, nil
+}
+
+// Covers 表示 date 所在年份的休市安排已收录
+func (c *HolidayCalendar) Covers(date time.Time) bool {
+	return c.years[date.Year()]
+}
+
+// IsTradingDay 按周末和休市安排判断 date 是否开市，未收录的年份只排除周末
+func (c *HolidayCalendar) IsTradingDay(date time.Time) bool {
+	switch date.Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	return !c.holidays[date.Format("2006-01-02")]
+}
@@ -0,0 +1,70 @@
+package tdx
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/jing2uo/tdx2db/model"
+)
+
+// capitalFields 为股本变动类记录的字段含义，依次对应 CapitalData 的
+// PrevOutstanding、PrevTotal、Outstanding、Total
+var capitalFields = []string{"前流通盘", "前总股本", "后流通盘", "后总股本"}
+
+// CapitalHistory 从股本变迁记录中提取股本结构变化，字段含义包含 capitalFields 的类型都会纳入。
+// 结果按代码和日期排序，同一天有多条记录时保留文件中最后一条，股本单位为万股。
+func CapitalHistory(data []model.GbbqData) ([]model.CapitalData, error) {
+	categories, err := GbbqCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	// 类型编号 -> c1..c4 中各字段的位置
+	positions := make(map[int][]int)
+	for _, category := range categories {
+		pos := make([]int, 0, len(capitalFields))
+		for _, field := range capitalFields {
+			i := slices.Index(category.Fields, field)
+			if i < 0 {
+				break
+			}
+			pos = append(pos, i)
+		}
+		if len(pos) == len(capitalFields) {
+			positions[category.ID] = pos
+		}
+	}
+
+	var result []model.CapitalData
+	for _, g := range data {
+		pos, ok := positions[g.Category]
+		if !ok {
+			continue
+		}
+		values := []float64{g.C1, g.C2, g.C3, g.C4}
+		result = append(result, model.CapitalData{
+			Code:            g.Code,
+			Date:            g.Date,
+			PrevOutstanding: values[pos[0]],
+			PrevTotal:       values[pos[1]],
+			Outstanding:     values[pos[2]],
+			Total:           values[pos[3]],
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Code != result[j].Code {
+			return result[i].Code < result[j].Code
+		}
+		return result[i].Date.Before(result[j].Date)
+	})
+
+	deduped := result[:0]
+	for i, c := range result {
+		if i+1 < len(result) && result[i+1].Code == c.Code && result[i+1].Date.Equal(c.Date) {
+			continue
+		}
+		deduped = append(deduped, c)
+	}
+	return deduped, nil
+}
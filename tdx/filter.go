@@ -0,0 +1,50 @@
+package tdx
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Filter 限定解析哪些文件和记录，零值表示不限制
+type Filter struct {
+	// Prefixes 为代码前缀，如 sh60，匹配任意一个即可
+	Prefixes []string
+	// Symbols 为完整代码，如 sh600000
+	Symbols []string
+	// From、To 为日期范围（包含），零值表示不限制，分钟数据按所在日期判断
+	From time.Time
+	To   time.Time
+}
+
+// matchSymbol 判断文件对应的代码是否需要解析，在打开文件前调用
+func (f Filter) matchSymbol(symbol string) bool {
+	if len(f.Symbols) > 0 && !slices.Contains(f.Symbols, symbol) {
+		return false
+	}
+	if len(f.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range f.Prefixes {
+		if strings.HasPrefix(symbol, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDate 判断记录日期是否在范围内
+func (f Filter) matchDate(date time.Time) bool {
+	if !f.From.IsZero() && date.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !date.Before(f.To.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+// hasDateRange 表示需要逐条检查记录日期
+func (f Filter) hasDateRange() bool {
+	return !f.From.IsZero() || !f.To.IsZero()
+}
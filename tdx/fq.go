@@ -1,6 +1,7 @@
 package tdx
 
 import (
+	"math"
 	"sort"
 	"time"
 
@@ -213,3 +214,58 @@ func calculatePreClose(stockData []model.StockData, xdxrData []model.XdxrData) (
 
 	return combined, nil
 }
+
+// QfqFactorAsOf 以 anchor 当天（或之前最近的交易日）为基准换算前复权因子，不包含 anchor 之后才发生的除权除息，
+// 适合无未来函数的回测。factors 为同一只股票已计算好的因子（如 raw_adjust_factor 中的记录），无需按日期排序；
+// 返回 anchor 及之前的记录，QfqFactor 为 qfq(t) / qfq(基准日)，其余字段保持不变。
+// 存储的因子已包含停牌期间除权除息的影响，换算后这些事件同样会计入。
+func QfqFactorAsOf(factors []model.Factor, anchor time.Time) []model.Factor {
+	result := make([]model.Factor, 0, len(factors))
+	for _, f := range factors {
+		if !f.Date.After(anchor) {
+			result = append(result, f)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Date.Before(result[j].Date) })
+	if len(result) == 0 {
+		return result
+	}
+
+	base := result[len(result)-1].QfqFactor
+	if base == 0 {
+		return result
+	}
+	for i := range result {
+		result[i].QfqFactor /= base
+	}
+	return result
+}
+
+// QfqAsOf 用 QfqFactorAsOf 得到的因子对日线做前复权，价格保留 2 位小数，与 v_qfq_stocks 一致。
+// 只返回 anchor 及之前且有因子的日线。
+func QfqAsOf(stockData []model.StockData, factors []model.Factor, anchor time.Time) []model.StockData {
+	dateFormat := "2006-01-02"
+	qfq := make(map[string]float64, len(factors))
+	for _, f := range QfqFactorAsOf(factors, anchor) {
+		qfq[f.Date.Format(dateFormat)] = f.QfqFactor
+	}
+
+	result := make([]model.StockData, 0, len(qfq))
+	for _, sd := range stockData {
+		factor, ok := qfq[sd.Date.Format(dateFormat)]
+		if !ok {
+			continue
+		}
+		sd.Open = roundPrice(sd.Open * factor)
+		sd.High = roundPrice(sd.High * factor)
+		sd.Low = roundPrice(sd.Low * factor)
+		sd.Close = roundPrice(sd.Close * factor)
+		result = append(result, sd)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Date.Before(result[j].Date) })
+	return result
+}
+
+func roundPrice(v float64) float64 {
+	return math.Round(v*100) / 100
+}
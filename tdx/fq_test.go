@@ -0,0 +1,80 @@
+package tdx
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jing2uo/tdx2db/model"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// suspendedExDateFactors 2024-01-04 停牌，当天 10 送 10 除权，复牌后价格减半
+func suspendedExDateFactors(t *testing.T) []model.Factor {
+	t.Helper()
+	stocks := []model.StockData{
+		{Symbol: "sh600000", Date: date("2024-01-02"), Open: 10, High: 10, Low: 10, Close: 10},
+		{Symbol: "sh600000", Date: date("2024-01-03"), Open: 10, High: 10, Low: 10, Close: 10},
+		{Symbol: "sh600000", Date: date("2024-01-05"), Open: 5, High: 5, Low: 5, Close: 5},
+		{Symbol: "sh600000", Date: date("2024-01-08"), Open: 5, High: 5, Low: 5, Close: 5},
+	}
+	xdxr := []model.XdxrData{
+		{Code: "600000", Date: date("2024-01-04"), Songzhuangu: 10},
+	}
+	factors, err := CalculateFqFactor(stocks, xdxr)
+	if err != nil {
+		t.Fatalf("CalculateFqFactor: %v", err)
+	}
+	return factors
+}
+
+func TestQfqFactorAsOfSuspendedExDate(t *testing.T) {
+	factors := suspendedExDateFactors(t)
+
+	tests := []struct {
+		anchor string
+		want   map[string]float64
+	}{
+		{"2024-01-08", map[string]float64{"2024-01-02": 0.5, "2024-01-03": 0.5, "2024-01-05": 1, "2024-01-08": 1}},
+		{"2024-01-06", map[string]float64{"2024-01-02": 0.5, "2024-01-03": 0.5, "2024-01-05": 1}},
+		{"2024-01-03", map[string]float64{"2024-01-02": 1, "2024-01-03": 1}},
+	}
+	for _, tt := range tests {
+		got := QfqFactorAsOf(factors, date(tt.anchor))
+		if len(got) != len(tt.want) {
+			t.Fatalf("anchor %s: got %d factors, want %d", tt.anchor, len(got), len(tt.want))
+		}
+		for _, f := range got {
+			day := f.Date.Format("2006-01-02")
+			if math.Abs(f.QfqFactor-tt.want[day]) > 1e-9 {
+				t.Errorf("anchor %s, %s: qfq %v, want %v", tt.anchor, day, f.QfqFactor, tt.want[day])
+			}
+		}
+	}
+}
+
+func TestQfqAsOfMatchesFullQfqAtLatestDate(t *testing.T) {
+	factors := suspendedExDateFactors(t)
+	stocks := []model.StockData{
+		{Symbol: "sh600000", Date: date("2024-01-02"), Open: 10, High: 10.4, Low: 9.8, Close: 10},
+		{Symbol: "sh600000", Date: date("2024-01-08"), Open: 5, High: 5.2, Low: 4.9, Close: 5},
+	}
+
+	got := QfqAsOf(stocks, factors, date("2024-01-08"))
+	if len(got) != 2 {
+		t.Fatalf("got %d rows, want 2", len(got))
+	}
+	if got[0].Close != 5 || got[0].High != 5.2 || got[0].Low != 4.9 {
+		t.Errorf("2024-01-02 adjusted to %+v, want close 5, high 5.2, low 4.9", got[0])
+	}
+	if got[1].Close != 5 {
+		t.Errorf("2024-01-08 close %v, want 5", got[1].Close)
+	}
+}
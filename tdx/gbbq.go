@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -57,6 +59,37 @@ func ConvertGbbqFile2Csv(gbbqFile, csvPath string) (string, error) {
 	return csvPath, nil
 }
 
+// ReadGbbqFile 解析股本变迁文件并返回全部记录
+func ReadGbbqFile(gbbqFile string) ([]model.GbbqData, error) {
+	if err := utils.CheckFile(gbbqFile); err != nil {
+		return nil, err
+	}
+
+	data, err := processGbbqFile(gbbqFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process GBBQ file: %w", err)
+	}
+	return data, nil
+}
+
+// GbbqCategories 根据 Category 和 CategoryDetail 返回全部股本变迁类型，按编号排序
+func GbbqCategories() ([]model.GbbqCategory, error) {
+	categories := make([]model.GbbqCategory, 0, len(Category))
+	for key, name := range Category {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GBBQ category %q: %w", key, err)
+		}
+		fields, ok := CategoryDetail[name]
+		if !ok {
+			return nil, fmt.Errorf("GBBQ category %s has no field detail", name)
+		}
+		categories = append(categories, model.GbbqCategory{ID: id, Name: name, Fields: fields})
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories, nil
+}
+
 func processGbbqFile(gbbqFile string) ([]model.GbbqData, error) {
 	hexStr := strings.ReplaceAll(HexKeys, " ", "")
 	keys, err := hex.DecodeString(hexStr)
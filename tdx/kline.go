@@ -3,23 +3,26 @@ package tdx
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jing2uo/tdx2db/model"
 )
 
 var maxConcurrency = runtime.NumCPU()
 
-// RowData 用于在生产者和消费者之间传递单行CSV数据或错误。
-type RowData struct {
-	Line string
-	Err  error
+// recordBatch 用于在生产者和消费者之间传递一批解析后的记录或错误。
+type recordBatch struct {
+	Records []model.StockData
+	Err     error
 }
 
 const (
@@ -32,29 +35,22 @@ const (
 )
 
 // 将通达信的 .day, .01, 或 .5 文件转换为CSV文件。
-func ConvertFiles2Csv(filePath string, validPrefixes []string, outputCSV string, suffix string) (string, error) {
-	// 1. 根据文件后缀选择CSV头部和记录处理器
-	var csvHeader string
-	var recordProcessor func(recordBytes []byte, symbol string) (string, error)
+func ConvertFiles2Csv(filePath string, filter Filter, outputCSV string, suffix string) (string, error) {
+	// 1. 根据文件后缀选择CSV头部和时间格式
+	var csvHeader, timeLayout string
 
 	switch suffix {
 	case ".day":
 		csvHeader = "symbol,open,high,low,close,amount,volume,date\n"
-		recordProcessor = processDayRecord
+		timeLayout = "2006-01-02"
 	case ".01", ".5":
 		csvHeader = "symbol,open,high,low,close,amount,volume,datetime\n"
-		recordProcessor = processMinRecord
+		timeLayout = "2006-01-02 15:04"
 	default:
 		return "", fmt.Errorf("unsupported file suffix: '%s'. Supported are .day, .01, .5", suffix)
 	}
 
-	// 2. 收集所有匹配的文件
-	files, err := collectFiles(filePath, validPrefixes, suffix)
-	if err != nil {
-		return "", err
-	}
-
-	// 3. 创建CSV文件并写入头部
+	// 2. 创建CSV文件并写入头部
 	outFile, err := os.Create(outputCSV)
 	if err != nil {
 		return "", fmt.Errorf("failed to create CSV file %s: %w", outputCSV, err)
@@ -65,49 +61,87 @@ func ConvertFiles2Csv(filePath string, validPrefixes []string, outputCSV string,
 		return "", fmt.Errorf("failed to write CSV header: %w", err)
 	}
 
-	// 4. 设置生产者-消费者模型
-	rowChan := make(chan RowData, 1024)
+	// 3. 解析记录并批量写入
+	batch := make([]string, 0, writeBatchSize)
+	err = ReadFiles(filePath, filter, suffix, func(record model.StockData) error {
+		batch = append(batch, formatCsvRow(record, timeLayout))
+		if len(batch) >= writeBatchSize {
+			if err := writeBatchToFile(outFile, batch); err != nil {
+				return err
+			}
+			batch = batch[:0] // 高效清空切片
+		}
+		return nil
+	})
+
+	// 处理最后一个未满的批次
+	if len(batch) > 0 {
+		if werr := writeBatchToFile(outFile, batch); werr != nil {
+			err = errors.Join(err, werr)
+		}
+	}
+	if err != nil {
+		return outputCSV, err
+	}
+
+	return outputCSV, nil
+}
+
+// ReadFiles 并发解析目录中的 .day, .01, 或 .5 文件，并将每条记录交给 handler。
+// handler 只在单个协程中被调用，可以安全地写入文件或数据库。
+// 不符合 filter 的文件不会打开，范围外的记录在解析后立即丢弃。
+// 分钟数据的 Date 字段包含时间部分。
+func ReadFiles(filePath string, filter Filter, suffix string, handler func(model.StockData) error) error {
+	// 1. 根据文件后缀选择记录解析器
+	var recordDecoder func(recordBytes []byte, symbol string) (model.StockData, error)
+
+	switch suffix {
+	case ".day":
+		recordDecoder = decodeDayRecord
+	case ".01", ".5":
+		recordDecoder = decodeMinRecord
+	default:
+		return fmt.Errorf("unsupported file suffix: '%s'. Supported are .day, .01, .5", suffix)
+	}
+
+	// 2. 收集所有匹配的文件
+	files, err := collectFiles(filePath, filter, suffix)
+	if err != nil {
+		return err
+	}
+
+	// 3. 设置生产者-消费者模型
+	batchChan := make(chan recordBatch, 64)
 	var producerWg sync.WaitGroup
 	var consumerWg sync.WaitGroup
 	sem := make(chan struct{}, maxConcurrency)
 
-	var errors []string
-	var errorMutex sync.Mutex
-	collectError := func(err error) {
-		errorMutex.Lock()
-		errors = append(errors, err.Error())
-		errorMutex.Unlock()
-	}
+	var errs []string
+	var handlerErr error
 
-	// 5. 启动消费者 (CSV写入器) Goroutine
+	// 4. 启动消费者 Goroutine，顺序调用 handler
 	consumerWg.Add(1)
 	go func() {
 		defer consumerWg.Done()
-		batch := make([]string, 0, writeBatchSize)
-
-		for data := range rowChan {
+		for data := range batchChan {
 			if data.Err != nil {
-				collectError(data.Err)
+				errs = append(errs, data.Err.Error())
 				continue
 			}
-			batch = append(batch, data.Line)
-			if len(batch) >= writeBatchSize {
-				if err := writeBatchToFile(outFile, batch); err != nil {
-					collectError(err)
-				}
-				batch = batch[:0] // 高效清空切片
+			if handlerErr != nil {
+				// handler 已失败，丢弃剩余数据直到生产者退出
+				continue
 			}
-		}
-
-		// 处理最后一个未满的批次
-		if len(batch) > 0 {
-			if err := writeBatchToFile(outFile, batch); err != nil {
-				collectError(err)
+			for _, record := range data.Records {
+				if err := handler(record); err != nil {
+					handlerErr = err
+					break
+				}
 			}
 		}
 	}()
 
-	// 6. 启动生产者 (文件读取器) Goroutines
+	// 5. 启动生产者 (文件读取器) Goroutines
 	for _, file := range files {
 		producerWg.Add(1)
 		sem <- struct{}{}
@@ -117,24 +151,27 @@ func ConvertFiles2Csv(filePath string, validPrefixes []string, outputCSV string,
 				producerWg.Done()
 			}()
 			// 调用通用的文件处理函数，它会将结果发送到channel
-			processAndProduce(filename, suffix, rowChan, recordProcessor)
+			processAndProduce(filename, suffix, filter, batchChan, recordDecoder)
 		}(file)
 	}
 
-	// 7. 等待所有任务完成
+	// 6. 等待所有任务完成
 	producerWg.Wait()
-	close(rowChan) // 关闭channel，通知消费者没有更多数据了
+	close(batchChan) // 关闭channel，通知消费者没有更多数据了
 	consumerWg.Wait()
 
-	if len(errors) > 0 {
-		return outputCSV, fmt.Errorf("errors occurred during processing:\n%s", strings.Join(errors, "\n"))
+	if handlerErr != nil {
+		return handlerErr
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("errors occurred during processing:\n%s", strings.Join(errs, "\n"))
 	}
 
-	return outputCSV, nil
+	return nil
 }
 
 // collectFiles 遍历目录并收集所有符合条件的文件路径。
-func collectFiles(filePath string, validPrefixes []string, suffix string) ([]string, error) {
+func collectFiles(filePath string, filter Filter, suffix string) ([]string, error) {
 	var files []string
 	err := filepath.WalkDir(filePath, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
@@ -142,11 +179,8 @@ func collectFiles(filePath string, validPrefixes []string, suffix string) ([]str
 		}
 		if !d.IsDir() && strings.HasSuffix(path, suffix) {
 			symbol := strings.TrimSuffix(filepath.Base(path), suffix)
-			for _, prefix := range validPrefixes {
-				if strings.HasPrefix(symbol, prefix) {
-					files = append(files, path)
-					return nil
-				}
+			if filter.matchSymbol(symbol) {
+				files = append(files, path)
 			}
 		}
 		return nil
@@ -155,16 +189,16 @@ func collectFiles(filePath string, validPrefixes []string, suffix string) ([]str
 		return nil, fmt.Errorf("failed to traverse directory %s: %w", filePath, err)
 	}
 	if len(files) == 0 {
-		return nil, fmt.Errorf("no valid '%s' files found with the given prefixes", suffix)
+		return nil, fmt.Errorf("no valid '%s' files found with the given prefixes or symbols", suffix)
 	}
 	return files, nil
 }
 
-// processAndProduce 读取单个文件，使用指定的处理器函数解析记录，并将结果发送到channel。
-func processAndProduce(filename, suffix string, rowChan chan<- RowData, processor func([]byte, string) (string, error)) {
+// processAndProduce 读取单个文件，使用指定的解析函数解析记录，并将结果按批发送到channel。
+func processAndProduce(filename, suffix string, filter Filter, batchChan chan<- recordBatch, decoder func([]byte, string) (model.StockData, error)) {
 	fileInfo, err := os.Stat(filename)
 	if err != nil {
-		rowChan <- RowData{Err: fmt.Errorf("could not stat file %s: %w", filename, err)}
+		batchChan <- recordBatch{Err: fmt.Errorf("could not stat file %s: %w", filename, err)}
 		return
 	}
 	if fileInfo.Size() == 0 {
@@ -173,13 +207,14 @@ func processAndProduce(filename, suffix string, rowChan chan<- RowData, processo
 
 	inFile, err := os.Open(filename)
 	if err != nil {
-		rowChan <- RowData{Err: fmt.Errorf("failed to open file %s: %w", filename, err)}
+		batchChan <- recordBatch{Err: fmt.Errorf("failed to open file %s: %w", filename, err)}
 		return
 	}
 	defer inFile.Close()
 
 	symbol := strings.TrimSuffix(filepath.Base(filename), suffix)
 	buffer := make([]byte, readBufferSize)
+	checkDate := filter.hasDateRange()
 
 	for {
 		n, err := inFile.Read(buffer)
@@ -187,23 +222,30 @@ func processAndProduce(filename, suffix string, rowChan chan<- RowData, processo
 			break
 		}
 		if err != nil {
-			rowChan <- RowData{Err: fmt.Errorf("failed to read file %s: %w", filename, err)}
+			batchChan <- recordBatch{Err: fmt.Errorf("failed to read file %s: %w", filename, err)}
 			return
 		}
 		if n%recordSize != 0 {
-			rowChan <- RowData{Err: fmt.Errorf("invalid file format in %s: data length %d is not a multiple of %d", filename, n, recordSize)}
+			batchChan <- recordBatch{Err: fmt.Errorf("invalid file format in %s: data length %d is not a multiple of %d", filename, n, recordSize)}
 			return
 		}
 
+		records := make([]model.StockData, 0, n/recordSize)
 		for i := 0; i < n/recordSize; i++ {
 			recordBytes := buffer[i*recordSize : (i+1)*recordSize]
-			csvLine, err := processor(recordBytes, symbol)
+			record, err := decoder(recordBytes, symbol)
 			if err != nil {
 				// 发送错误，但继续处理文件中的其他记录
-				rowChan <- RowData{Err: fmt.Errorf("failed to process record in %s: %w", filename, err)}
+				batchChan <- recordBatch{Err: fmt.Errorf("failed to process record in %s: %w", filename, err)}
+				continue
+			}
+			if checkDate && !filter.matchDate(record.Date) {
 				continue
 			}
-			rowChan <- RowData{Line: csvLine}
+			records = append(records, record)
+		}
+		if len(records) > 0 {
+			batchChan <- recordBatch{Records: records}
 		}
 	}
 }
@@ -216,68 +258,87 @@ func writeBatchToFile(file *os.File, batch []string) error {
 	return nil
 }
 
-// --- 特定记录处理函数 ---
+func formatCsvRow(record model.StockData, timeLayout string) string {
+	return fmt.Sprintf("%s,%.2f,%.2f,%.2f,%.2f,%.2f,%d,%s\n",
+		record.Symbol,
+		record.Open,
+		record.High,
+		record.Low,
+		record.Close,
+		record.Amount,
+		record.Volume,
+		record.Date.Format(timeLayout))
+}
+
+// --- 特定记录解析函数 ---
 
-func processDayRecord(data []byte, symbol string) (string, error) {
+func decodeDayRecord(data []byte, symbol string) (model.StockData, error) {
 	var record model.DayfileRecord
 	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &record); err != nil {
-		return "", fmt.Errorf("binary read failed: %w", err)
+		return model.StockData{}, fmt.Errorf("binary read failed: %w", err)
 	}
-	dateStr, err := formatDate(record.Date)
+	date, err := parseDate(record.Date)
 	if err != nil {
-		return "", err
+		return model.StockData{}, err
 	}
-	return fmt.Sprintf("%s,%.2f,%.2f,%.2f,%.2f,%.2f,%d,%s\n",
-		symbol,
-		float64(record.Open)/100,
-		float64(record.High)/100,
-		float64(record.Low)/100,
-		float64(record.Close)/100,
-		record.Amount,
-		record.Volume,
-		dateStr), nil
+	return model.StockData{
+		Symbol: symbol,
+		Open:   float64(record.Open) / 100,
+		High:   float64(record.High) / 100,
+		Low:    float64(record.Low) / 100,
+		Close:  float64(record.Close) / 100,
+		Amount: roundAmount(record.Amount),
+		Volume: int64(record.Volume),
+		Date:   date,
+	}, nil
 }
 
-func processMinRecord(data []byte, symbol string) (string, error) {
+func decodeMinRecord(data []byte, symbol string) (model.StockData, error) {
 	var record model.MinfileRecord
 	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &record); err != nil {
-		return "", fmt.Errorf("binary read failed: %w", err)
+		return model.StockData{}, fmt.Errorf("binary read failed: %w", err)
 	}
-	dateTimeStr, err := formatDateTime(record.DateRaw, record.TimeRaw)
+	dateTime, err := parseDateTime(record.DateRaw, record.TimeRaw)
 	if err != nil {
-		return "", err
+		return model.StockData{}, err
 	}
-	return fmt.Sprintf("%s,%.2f,%.2f,%.2f,%.2f,%.2f,%d,%s\n",
-		symbol,
-		float64(record.Open)/100,
-		float64(record.High)/100,
-		float64(record.Low)/100,
-		float64(record.Close)/100,
-		record.Amount,
-		record.Volume,
-		dateTimeStr), nil
+	return model.StockData{
+		Symbol: symbol,
+		Open:   float64(record.Open) / 100,
+		High:   float64(record.High) / 100,
+		Low:    float64(record.Low) / 100,
+		Close:  float64(record.Close) / 100,
+		Amount: roundAmount(record.Amount),
+		Volume: int64(record.Volume),
+		Date:   dateTime,
+	}, nil
+}
+
+// roundAmount 将成交额保留两位小数，与 CSV 输出一致
+func roundAmount(amount float32) float64 {
+	return math.Round(float64(amount)*100) / 100
 }
 
-func formatDate(date uint32) (string, error) {
+func parseDate(date uint32) (time.Time, error) {
 	d := int(date)
 	year, month, day := d/10000, (d%10000)/100, d%100
 	if year < 1990 || year > 2100 || month < 1 || month > 12 || day < 1 || day > 31 {
-		return "", fmt.Errorf("invalid date value: %08d", date)
+		return time.Time{}, fmt.Errorf("invalid date value: %08d", date)
 	}
-	return fmt.Sprintf("%04d-%02d-%02d", year, month, day), nil
+	return marketWallClock(year, time.Month(month), day, 0, 0), nil
 }
 
-func formatDateTime(dateRaw, timeRaw uint16) (string, error) {
+func parseDateTime(dateRaw, timeRaw uint16) (time.Time, error) {
 	year := int(dateRaw)/2048 + 2004
 	month := (int(dateRaw) % 2048) / 100
 	day := (int(dateRaw) % 2048) % 100
 	hour := int(timeRaw) / 60
 	minute := int(timeRaw) % 60
 	if year < 1990 || year > 2100 || month < 1 || month > 12 || day < 1 || day > 31 {
-		return "", fmt.Errorf("invalid date value from raw: %d", dateRaw)
+		return time.Time{}, fmt.Errorf("invalid date value from raw: %d", dateRaw)
 	}
 	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
-		return "", fmt.Errorf("invalid time value from raw: %d", timeRaw)
+		return time.Time{}, fmt.Errorf("invalid time value from raw: %d", timeRaw)
 	}
-	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d", year, month, day, hour, minute), nil
+	return marketWallClock(year, time.Month(month), day, hour, minute), nil
 }
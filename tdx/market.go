@@ -0,0 +1,30 @@
+package tdx
+
+import "time"
+
+// DefaultMarketTimezone 为沪深北交易所所在时区
+const DefaultMarketTimezone = "Asia/Shanghai"
+
+// MarketLocation 为交易所时区，交易日期和数据文件中的时间都以该时区为准
+var MarketLocation = loadMarketLocation(DefaultMarketTimezone)
+
+func loadMarketLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		// 中国不实行夏令时，系统缺少时区数据时用固定偏移代替
+		return time.FixedZone("CST", 8*60*60)
+	}
+	return loc
+}
+
+// MarketDate 返回 t 在交易所时区的日期，以 UTC 零点表示，与 DuckDB DATE 读出的值一致
+func MarketDate(t time.Time) time.Time {
+	local := t.In(MarketLocation)
+	return marketWallClock(local.Year(), local.Month(), local.Day(), 0, 0)
+}
+
+// marketWallClock 将交易所本地时间表示为读数相同的 UTC 时间。
+// DuckDB 的 DATE 和 TIMESTAMP 不带时区，按读数写入才能在任何服务器时区下都存为交易所时间。
+func marketWallClock(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
@@ -0,0 +1,117 @@
+package tdx
+
+import "strings"
+
+// 交易所
+const (
+	ExchangeSSE  = "SSE"  // 上海证券交易所
+	ExchangeSZSE = "SZSE" // 深圳证券交易所
+	ExchangeBSE  = "BSE"  // 北京证券交易所
+)
+
+// 股票所属板块，非股票为空
+const (
+	BoardMain    = "main"    // 沪深主板（含 B 股）
+	BoardChiNext = "chinext" // 创业板
+	BoardSTAR    = "star"    // 科创板
+	BoardBSE     = "bse"     // 北证
+)
+
+// 品种类型
+const (
+	TypeStock = "stock"
+	TypeIndex = "index"
+	TypeBlock = "block" // 通达信概念、风格和行业板块
+	TypeETF   = "etf"   // 场内基金，包括 ETF、LOF 和封闭式基金
+	TypeBond  = "bond"  // 可转债、国债、企业债和回购
+	TypeOther = "other"
+)
+
+// SymbolClass 为按代码规则推断出的品种分类
+type SymbolClass struct {
+	Exchange string
+	Board    string
+	Type     string
+}
+
+// classRule 为一条代码前缀规则，按顺序匹配，较长的前缀需要排在前面
+type classRule struct {
+	prefix string
+	board  string
+	kind   string
+}
+
+var classRules = map[string][]classRule{
+	"sh": {
+		{"600", BoardMain, TypeStock},
+		{"601", BoardMain, TypeStock},
+		{"603", BoardMain, TypeStock},
+		{"605", BoardMain, TypeStock},
+		{"688", BoardSTAR, TypeStock},
+		{"689", BoardSTAR, TypeStock},
+		{"900", BoardMain, TypeStock},
+		{"000", "", TypeIndex},
+		{"880", "", TypeBlock},
+		{"881", "", TypeBlock},
+		{"50", "", TypeETF},
+		{"51", "", TypeETF},
+		{"52", "", TypeETF},
+		{"56", "", TypeETF},
+		{"58", "", TypeETF},
+		{"01", "", TypeBond},
+		{"02", "", TypeBond},
+		{"1", "", TypeBond},
+		{"204", "", TypeBond},
+	},
+	"sz": {
+		{"000", BoardMain, TypeStock},
+		{"001", BoardMain, TypeStock},
+		{"002", BoardMain, TypeStock},
+		{"003", BoardMain, TypeStock},
+		{"004", BoardMain, TypeStock},
+		{"200", BoardMain, TypeStock},
+		{"300", BoardChiNext, TypeStock},
+		{"301", BoardChiNext, TypeStock},
+		{"302", BoardChiNext, TypeStock},
+		{"399", "", TypeIndex},
+		{"15", "", TypeETF},
+		{"16", "", TypeETF},
+		{"18", "", TypeETF},
+		{"10", "", TypeBond},
+		{"11", "", TypeBond},
+		{"12", "", TypeBond},
+		{"13", "", TypeBond},
+	},
+	"bj": {
+		{"920", BoardBSE, TypeStock},
+		{"43", BoardBSE, TypeStock},
+		{"83", BoardBSE, TypeStock},
+		{"87", BoardBSE, TypeStock},
+		{"88", BoardBSE, TypeStock},
+		{"899", "", TypeIndex},
+	},
+}
+
+var exchanges = map[string]string{
+	"sh": ExchangeSSE,
+	"sz": ExchangeSZSE,
+	"bj": ExchangeBSE,
+}
+
+// ClassifySymbol 按代码规则推断交易所、板块和品种类型，如 sh688001 为上交所科创板股票。
+// 无法识别的代码类型为 other。
+func ClassifySymbol(symbol string) SymbolClass {
+	if len(symbol) < 2 {
+		return SymbolClass{Type: TypeOther}
+	}
+	market, code := symbol[:2], symbol[2:]
+	class := SymbolClass{Exchange: exchanges[market], Type: TypeOther}
+	for _, rule := range classRules[market] {
+		if strings.HasPrefix(code, rule.prefix) {
+			class.Board = rule.board
+			class.Type = rule.kind
+			break
+		}
+	}
+	return class
+}
@@ -1,7 +1,17 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 func GetCacheDir() (string, error) {
@@ -12,3 +22,192 @@ func GetCacheDir() (string, error) {
 
 	return appDir, nil
 }
+
+// DefaultDownloadCacheDir 返回持久化下载缓存的默认目录（Linux 下为 $XDG_CACHE_HOME/tdx2db）
+func DefaultDownloadCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user cache dir: %w", err)
+	}
+	return filepath.Join(dir, "tdx2db"), nil
+}
+
+// DownloadCache 持久化的下载缓存。
+// 文件按首选 URL 和日期保存，同时记录大小、ETag 和 Last-Modified：
+// 同一 URL 和日期命中缓存时不访问网络；日期不同或需要重新校验时用最近一份缓存的校验信息发起条件 GET，
+// 返回 304 则直接复用，否则这次响应的内容和校验信息写入缓存。
+type DownloadCache struct {
+	Dir string
+}
+
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	Date         string    `json:"date"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// Fetch 从缓存或 urls 获取 date 对应的文件并复制到 targetPath，返回值与 DownloadFile 相同。
+// revalidate 为 true 时即使同一日期已缓存也会发起条件 GET，用于 URL 不含日期、内容会随时更新的文件
+func (c *DownloadCache) Fetch(urls []string, date string, targetPath string, revalidate bool) (int, error) {
+	if len(urls) == 0 {
+		return 0, fmt.Errorf("no download url")
+	}
+
+	keyDir := filepath.Join(c.Dir, cacheKey(urls[0]))
+	if err := os.MkdirAll(keyDir, 0755); err != nil {
+		return 0, fmt.Errorf("create cache dir: %w", err)
+	}
+
+	// 1. 同一 URL 和日期已缓存
+	if entry, ok := c.load(keyDir, date); ok && !revalidate {
+		if err := CopyFile(c.dataPath(keyDir, entry.Date), targetPath); err != nil {
+			return 0, fmt.Errorf("copy cached file: %w", err)
+		}
+		return http.StatusOK, nil
+	}
+
+	// 2. 用最近一份缓存的校验信息发起条件 GET，未变化时复用，否则响应体直接写入缓存
+	prev, hasPrev := c.latest(keyDir)
+	dataPath := c.dataPath(keyDir, date)
+	status, header, err := c.conditionalGet(urls, prev, hasPrev, dataPath)
+	if err != nil {
+		return status, err
+	}
+
+	var entry cacheEntry
+	switch status {
+	case http.StatusNotFound:
+		return http.StatusNotFound, nil
+	case http.StatusNotModified:
+		if !hasPrev {
+			return status, fmt.Errorf("unexpected status: %d", status)
+		}
+		entry = prev
+		entry.Date = date
+		if prev.Date != date {
+			if err := CopyFile(c.dataPath(keyDir, prev.Date), dataPath); err != nil {
+				return 0, fmt.Errorf("copy cached file: %w", err)
+			}
+		}
+	default:
+		info, err := os.Stat(dataPath)
+		if err != nil {
+			return status, fmt.Errorf("stat downloaded file: %w", err)
+		}
+		// 校验信息取自写入文件的这次响应
+		entry = cacheEntry{
+			URL:          urls[0],
+			Date:         date,
+			Size:         info.Size(),
+			ETag:         header.Get("ETag"),
+			LastModified: header.Get("Last-Modified"),
+		}
+	}
+	entry.FetchedAt = time.Now()
+	if err := c.store(keyDir, entry); err != nil {
+		return 0, err
+	}
+
+	if err := CopyFile(dataPath, targetPath); err != nil {
+		return 0, fmt.Errorf("copy cached file: %w", err)
+	}
+	return http.StatusOK, nil
+}
+
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c *DownloadCache) dataPath(keyDir, date string) string {
+	return filepath.Join(keyDir, date+".data")
+}
+
+func (c *DownloadCache) metaPath(keyDir, date string) string {
+	return filepath.Join(keyDir, date+".json")
+}
+
+// load 读取缓存记录，并校验数据文件大小
+func (c *DownloadCache) load(keyDir, date string) (cacheEntry, bool) {
+	var entry cacheEntry
+	data, err := os.ReadFile(c.metaPath(keyDir, date))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false
+	}
+	info, err := os.Stat(c.dataPath(keyDir, date))
+	if err != nil || info.Size() != entry.Size {
+		return entry, false
+	}
+	return entry, true
+}
+
+// latest 返回日期最新的有效缓存记录
+func (c *DownloadCache) latest(keyDir string) (cacheEntry, bool) {
+	metas, err := filepath.Glob(filepath.Join(keyDir, "*.json"))
+	if err != nil || len(metas) == 0 {
+		return cacheEntry{}, false
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(metas)))
+	for _, m := range metas {
+		date := strings.TrimSuffix(filepath.Base(m), ".json")
+		if entry, ok := c.load(keyDir, date); ok {
+			return entry, true
+		}
+	}
+	return cacheEntry{}, false
+}
+
+func (c *DownloadCache) store(keyDir string, entry cacheEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode cache entry: %w", err)
+	}
+	if err := os.WriteFile(c.metaPath(keyDir, entry.Date), data, 0644); err != nil {
+		return fmt.Errorf("write cache entry: %w", err)
+	}
+	return nil
+}
+
+// conditionalGet 依次从 urls 下载到 dataPath，有上一份缓存时带上 If-None-Match/If-Modified-Since。
+// 下载通过 streamDownload 进行，中断后从 c.Dir/parts 中已写入的部分续传。
+// 返回 200 时同时返回写入文件的那个版本的校验信息；304 和 404 不写文件。
+// 每个请求在网络错误、429 或 5xx 时按 withRetry 重试，仍失败时换下一个镜像，其他状态码直接返回。
+func (c *DownloadCache) conditionalGet(urls []string, prev cacheEntry, hasPrev bool, dataPath string) (int, http.Header, error) {
+	partDir := filepath.Join(c.Dir, "parts")
+	if err := os.MkdirAll(partDir, 0755); err != nil {
+		return 0, nil, fmt.Errorf("create part dir: %w", err)
+	}
+
+	conditional := http.Header{}
+	if hasPrev {
+		if prev.ETag != "" {
+			conditional.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			conditional.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	var errs []error
+	var status int
+	for _, url := range urls {
+		d := &Download{Url: url, Target: dataPath, PartDir: partDir}
+		var header http.Header
+		var err error
+		status, header, err = d.streamDownload(conditional)
+		if err == nil {
+			return status, header, nil
+		}
+		if !isMirrorFailure(status) {
+			return status, nil, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	return status, nil, errors.Join(errs...)
+}
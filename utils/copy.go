@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// CopyFile 将 srcPath 复制到 dstPath，dstPath 已存在时会被覆盖
+func CopyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return fmt.Errorf("create target: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("copy data: %w", err)
+	}
+	return dst.Close()
+}
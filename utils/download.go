@@ -1,45 +1,131 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+const (
+	// 等待响应头的最长时间
+	responseHeaderTimeout = 30 * time.Second
+	// 读取响应体时超过这么久没有收到数据即中断，重试时从已写入的位置续传。
+	// 不限制整个响应体的耗时，慢速网络下的大文件也能下载完
+	idleTimeout = time.Minute
+	// 每个请求失败后的最大重试次数
+	maxRetries = 4
+	// 重试退避的基础时长，第 n 次重试等待 base*2^(n-1) 加随机抖动
+	retryBaseDelay = time.Second
+)
+
+// httpClient 所有下载共用的客户端，设置了连接、握手和响应头超时，响应体的空闲超时见 doRequest
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   15 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   10,
+	},
+}
+
+// errSizeMismatch 表示下载的文件大小与 Content-Length 不一致
+var errSizeMismatch = errors.New("size mismatch")
+
 // Download 封装下载任务
 type Download struct {
 	Url           string
 	Target        string
 	TotalSections int
+	// PartDir 保存未完成的分段和续传文件，文件按 URL 命名，跨运行保留，下载成功后删除
+	PartDir string
+}
+
+// DefaultPartDir 返回未完成下载的默认保存目录（Linux 下为 $XDG_CACHE_HOME/tdx2db/parts），
+// 无法定位用户缓存目录时使用系统临时目录
+func DefaultPartDir() string {
+	if dir, err := DefaultDownloadCacheDir(); err == nil {
+		return filepath.Join(dir, "parts")
+	}
+	return filepath.Join(os.TempDir(), "tdx2db-parts")
 }
 
-// DownloadFile 下载文件并返回 HTTP 状态码。
+// DownloadFile 依次尝试 urls 中的镜像下载文件，并返回 HTTP 状态码。
+// 每个请求在网络错误、429 或 5xx 时带抖动指数退避重试，仍失败时换下一个镜像，其他状态码直接返回。
 // 若状态码为 404 或 200，error 为 nil。
-// 若服务器不支持 Range，则自动降级为单线程下载（静默处理）。
-func DownloadFile(url string, targetPath string) (int, error) {
+// 未完成的分段保存在 DefaultPartDir 中，进程中断后再次运行会从已有偏移续传。
+// 若服务器不支持 Range，则自动降级为单连接下载（静默处理），单连接下载同样可以续传。
+func DownloadFile(urls []string, targetPath string) (int, error) {
+	if len(urls) == 0 {
+		return 0, errors.New("no download url")
+	}
+
+	var errs []error
+	var status int
+	for _, url := range urls {
+		var err error
+		status, err = downloadFromURL(url, targetPath)
+		if err == nil {
+			return status, nil
+		}
+		if !isMirrorFailure(status) {
+			return status, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	return status, errors.Join(errs...)
+}
+
+// isMirrorFailure 判断重试后仍失败的请求是否应换下一个镜像：网络错误、429 和 5xx
+func isMirrorFailure(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+func downloadFromURL(url string, targetPath string) (int, error) {
 	const totalSections = 5
 
 	d := &Download{
 		Url:           url,
 		Target:        targetPath,
 		TotalSections: totalSections,
+		PartDir:       DefaultPartDir(),
+	}
+	if err := os.MkdirAll(d.PartDir, 0755); err != nil {
+		return 0, fmt.Errorf("create part dir: %w", err)
 	}
 
 	// Step 1: HEAD 获取文件元信息
-	r, err := d.getNewRequest("HEAD")
-	if err != nil {
-		return 0, fmt.Errorf("create HEAD request: %w", err)
-	}
-	res, err := http.DefaultClient.Do(r)
+	var res *http.Response
+	statusCode, err := withRetry(func() (int, error) {
+		r, err := d.getNewRequest("HEAD")
+		if err != nil {
+			return 0, fmt.Errorf("create HEAD request: %w", err)
+		}
+		res, err = doRequest(r)
+		if err != nil {
+			return 0, fmt.Errorf("execute HEAD request: %w", err)
+		}
+		res.Body.Close()
+		return res.StatusCode, nil
+	})
 	if err != nil {
-		return 0, fmt.Errorf("execute HEAD request: %w", err)
+		return statusCode, err
 	}
-	defer res.Body.Close()
-
-	statusCode := res.StatusCode
 
 	if statusCode == http.StatusNotFound {
 		return 404, nil
@@ -51,24 +137,41 @@ func DownloadFile(url string, targetPath string) (int, error) {
 	// Step 2: 检查是否能获取 Content-Length
 	size, err := strconv.Atoi(res.Header.Get("Content-Length"))
 	if err != nil || size <= 0 {
-		return d.singleThreadDownload()
+		status, _, err := d.streamDownload(nil)
+		return status, err
 	}
 
 	// Step 3: 检查服务器是否支持 Range
-	testReq, _ := d.getNewRequest("GET")
-	testReq.Header.Set("Range", "bytes=0-0")
-	testResp, err := http.DefaultClient.Do(testReq)
+	rangeStatus, err := withRetry(func() (int, error) {
+		testReq, err := d.getNewRequest("GET")
+		if err != nil {
+			return 0, err
+		}
+		testReq.Header.Set("Range", "bytes=0-0")
+		testResp, err := doRequest(testReq)
+		if err != nil {
+			return 0, fmt.Errorf("range test request: %w", err)
+		}
+		testResp.Body.Close()
+		return testResp.StatusCode, nil
+	})
 	if err != nil {
-		return 0, fmt.Errorf("range test request: %w", err)
+		return rangeStatus, err
+	}
+
+	if rangeStatus != http.StatusPartialContent {
+		// 不支持 Range -> 自动降级为单连接下载
+		status, _, err := d.streamDownload(nil)
+		return status, err
 	}
-	defer testResp.Body.Close()
 
-	if testResp.StatusCode != http.StatusPartialContent {
-		// 不支持 Range -> 自动降级为单线程
-		return d.singleThreadDownload()
+	// Step 4: 已有分段属于其他版本的文件时丢弃，避免拼出损坏的文件
+	version := fmt.Sprintf("%d %s %s", size, res.Header.Get("ETag"), res.Header.Get("Last-Modified"))
+	if err := d.preparePartVersion(version); err != nil {
+		return statusCode, err
 	}
 
-	// Step 4: 执行并发下载
+	// Step 5: 执行并发下载
 	eachSize := size / d.TotalSections
 	sections := make([][2]int, d.TotalSections)
 	for i := range sections {
@@ -86,35 +189,64 @@ func DownloadFile(url string, targetPath string) (int, error) {
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	var sectionErr error
+	var sectionErrs []error
 
 	for i, sec := range sections {
 		wg.Add(1)
 		go func(i int, sec [2]int) {
 			defer wg.Done()
-			if err := d.downloadSection(i, sec); err != nil {
+			_, err := withRetry(func() (int, error) {
+				return d.downloadSection(i, sec)
+			})
+			if err != nil {
 				mu.Lock()
-				if sectionErr == nil {
-					sectionErr = err
-				}
+				sectionErrs = append(sectionErrs, err)
 				mu.Unlock()
 			}
 		}(i, sec)
 	}
 	wg.Wait()
 
-	if sectionErr != nil {
-		// 自动降级
-		return d.singleThreadDownload()
+	if len(sectionErrs) > 0 {
+		// 保留已下载的分段，下次从断点续传
+		return 0, fmt.Errorf("download sections: %w", errors.Join(sectionErrs...))
 	}
 
-	if err := d.mergeSections(sections); err != nil {
+	if err := d.mergeSections(sections, int64(size)); err != nil {
 		return statusCode, fmt.Errorf("merge sections: %w", err)
 	}
 
 	return statusCode, nil
 }
 
+// withRetry 执行 fn，在网络错误、429 或 5xx 时按带抖动的指数退避重试
+func withRetry(fn func() (int, error)) (int, error) {
+	var status int
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := retryBaseDelay << (attempt - 1)
+			time.Sleep(delay + rand.N(delay))
+		}
+		status, err = fn()
+		if !isRetryable(status, err) {
+			return status, err
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected status: %d", status)
+	}
+	return status, fmt.Errorf("giving up after %d retries: %w", maxRetries, err)
+}
+
+func isRetryable(status int, err error) bool {
+	if err != nil && status == 0 {
+		// 状态码为 0 表示请求没有得到响应或响应体中断
+		return true
+	}
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
 func (d *Download) getNewRequest(method string) (*http.Request, error) {
 	r, err := http.NewRequest(method, d.Url, nil)
 	if err != nil {
@@ -124,81 +256,312 @@ func (d *Download) getNewRequest(method string) (*http.Request, error) {
 	return r, nil
 }
 
-func (d *Download) downloadSection(i int, section [2]int) error {
+// partPrefix 返回 PartDir 中该 URL 对应文件的路径前缀，同一 URL 每次运行都相同
+func (d *Download) partPrefix() string {
+	return filepath.Join(d.PartDir, cacheKey(d.Url))
+}
+
+func (d *Download) partFile(i int) string {
+	return fmt.Sprintf("%s.part%d", d.partPrefix(), i)
+}
+
+func (d *Download) partVersionFile() string {
+	return d.partPrefix() + ".partinfo"
+}
+
+// preparePartVersion 记录分段对应的文件版本，版本不同时删除旧分段
+func (d *Download) preparePartVersion(version string) error {
+	old, err := os.ReadFile(d.partVersionFile())
+	if err == nil && string(old) == version {
+		return nil
+	}
+	for i := 0; i < d.TotalSections; i++ {
+		_ = os.Remove(d.partFile(i))
+	}
+	if err := os.WriteFile(d.partVersionFile(), []byte(version), 0644); err != nil {
+		return fmt.Errorf("write part info: %w", err)
+	}
+	return nil
+}
+
+// downloadSection 下载一个分段，已存在的 .partN 文件从其末尾续传
+func (d *Download) downloadSection(i int, section [2]int) (int, error) {
+	expected := int64(section[1] - section[0] + 1)
+	partFile := d.partFile(i)
+
+	var offset int64
+	if info, err := os.Stat(partFile); err == nil {
+		offset = info.Size()
+	}
+	if offset == expected {
+		return http.StatusPartialContent, nil
+	}
+	if offset > expected {
+		offset = 0
+	}
+
 	r, err := d.getNewRequest("GET")
 	if err != nil {
-		return fmt.Errorf("create section %d request: %w", i, err)
+		return 0, fmt.Errorf("create section %d request: %w", i, err)
 	}
-	r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", section[0], section[1]))
+	r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", int64(section[0])+offset, section[1]))
 
-	resp, err := http.DefaultClient.Do(r)
+	resp, err := doRequest(r)
 	if err != nil {
-		return fmt.Errorf("execute section %d: %w", i, err)
+		return 0, fmt.Errorf("execute section %d: %w", i, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected section %d status: %d", i, resp.StatusCode)
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.StatusCode, fmt.Errorf("unexpected section %d status: %d", i, resp.StatusCode)
 	}
 
-	f, err := os.Create(fmt.Sprintf("%s.part%d", d.Target, i))
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if offset == 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+	f, err := os.OpenFile(partFile, flags, 0644)
 	if err != nil {
-		return fmt.Errorf("create part file %d: %w", i, err)
+		return resp.StatusCode, fmt.Errorf("create part file %d: %w", i, err)
 	}
 	defer f.Close()
 
-	if _, err := io.Copy(f, resp.Body); err != nil {
-		return fmt.Errorf("write part %d: %w", i, err)
+	written, err := io.Copy(f, resp.Body)
+	if err != nil {
+		// 已写入的部分保留，重试时续传；返回 0 让 withRetry 视为网络错误
+		return 0, fmt.Errorf("write part %d: %w", i, err)
+	}
+	if offset+written != expected {
+		return 0, fmt.Errorf("part %d incomplete: got %d of %d bytes", i, offset+written, expected)
 	}
 
-	return nil
+	return resp.StatusCode, nil
 }
 
-func (d *Download) mergeSections(sections [][2]int) error {
-	f, err := os.Create(d.Target)
+func (d *Download) mergeSections(sections [][2]int, size int64) error {
+	tmpTarget := d.Target + ".tmp"
+	f, err := os.Create(tmpTarget)
 	if err != nil {
 		return fmt.Errorf("create target: %w", err)
 	}
 	defer f.Close()
 
 	for i := 0; i < len(sections); i++ {
-		partFile := fmt.Sprintf("%s.part%d", d.Target, i)
-		data, err := os.ReadFile(partFile)
+		part, err := os.Open(d.partFile(i))
 		if err != nil {
-			return fmt.Errorf("read part file %s: %w", partFile, err)
+			return fmt.Errorf("read part file %s: %w", d.partFile(i), err)
 		}
-		if _, err := f.Write(data); err != nil {
+		_, err = io.Copy(f, part)
+		part.Close()
+		if err != nil {
 			return fmt.Errorf("write part %d: %w", i, err)
 		}
-		_ = os.Remove(partFile)
 	}
+
+	if err := verifySize(f, size); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close target: %w", err)
+	}
+	if err := os.Rename(tmpTarget, d.Target); err != nil {
+		return fmt.Errorf("rename target: %w", err)
+	}
+
+	for i := 0; i < len(sections); i++ {
+		_ = os.Remove(d.partFile(i))
+	}
+	_ = os.Remove(d.partVersionFile())
 	return nil
 }
 
-// singleThreadDownload 用于降级时的完整文件下载
-func (d *Download) singleThreadDownload() (int, error) {
-	resp, err := http.Get(d.Url)
+// verifySize 校验文件大小与 Content-Length 一致，size 小于 0 时跳过
+func verifySize(f *os.File, size int64) error {
+	if size < 0 {
+		return nil
+	}
+	info, err := f.Stat()
 	if err != nil {
-		return 0, fmt.Errorf("single-thread GET: %w", err)
+		return fmt.Errorf("stat target: %w", err)
 	}
-	defer resp.Body.Close()
+	if info.Size() != size {
+		return fmt.Errorf("%w: expected %d, got %d", errSizeMismatch, size, info.Size())
+	}
+	return nil
+}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return 404, nil
+// doRequest 发送请求，响应体超过 idleTimeout 没有收到数据时取消请求，读取返回错误
+func doRequest(r *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	resp, err := httpClient.Do(r.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return resp.StatusCode, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	resp.Body = &idleBody{
+		ReadCloser: resp.Body,
+		timer:      time.AfterFunc(idleTimeout, cancel),
+		cancel:     cancel,
 	}
+	return resp, nil
+}
 
-	f, err := os.Create(d.Target)
-	if err != nil {
-		return resp.StatusCode, fmt.Errorf("create target: %w", err)
+// idleBody 每次读到数据时重置空闲计时器
+type idleBody struct {
+	io.ReadCloser
+	timer  *time.Timer
+	cancel context.CancelFunc
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(idleTimeout)
 	}
-	defer f.Close()
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// streamInfo 记录单连接下载中已写入部分对应的文件版本，用于续传时的 If-Range
+type streamInfo struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Size 为完整文件大小，未知时为 -1
+	Size int64 `json:"size"`
+}
 
-	if _, err := io.Copy(f, resp.Body); err != nil {
-		return resp.StatusCode, fmt.Errorf("write target: %w", err)
+func (d *Download) streamFile() string {
+	return d.partPrefix() + ".stream"
+}
+
+func (d *Download) streamInfoFile() string {
+	return d.partPrefix() + ".streaminfo"
+}
+
+// loadStream 返回已写入的字节数和对应的版本，没有可续传的内容时返回 0
+func (d *Download) loadStream() (int64, streamInfo) {
+	var info streamInfo
+	data, err := os.ReadFile(d.streamInfoFile())
+	if err != nil || json.Unmarshal(data, &info) != nil || (info.ETag == "" && info.LastModified == "") {
+		return 0, info
 	}
+	stat, err := os.Stat(d.streamFile())
+	if err != nil || (info.Size >= 0 && stat.Size() > info.Size) {
+		return 0, info
+	}
+	return stat.Size(), info
+}
 
-	return resp.StatusCode, nil
+// streamDownload 用单个连接下载，响应体先写入 PartDir 中按 URL 命名的 .stream 文件。
+// 中断后（包括进程退出后再次运行）用 Range 和 If-Range 从已写入的位置续传；
+// 服务器不支持 Range、文件已变化或没有 ETag/Last-Modified 时从头下载。
+// conditional 中的请求头（如 If-None-Match）只在从头下载时发送，服务器返回 304 或 404 时原样返回。
+// 成功时返回的响应头包含写入文件的那个版本的 ETag、Last-Modified 和 Content-Length。
+func (d *Download) streamDownload(conditional http.Header) (int, http.Header, error) {
+	var result http.Header
+	status, err := withRetry(func() (int, error) {
+		offset, info := d.loadStream()
+
+		r, err := d.getNewRequest("GET")
+		if err != nil {
+			return 0, err
+		}
+		if offset > 0 {
+			r.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			validator := info.ETag
+			if validator == "" {
+				validator = info.LastModified
+			}
+			r.Header.Set("If-Range", validator)
+		} else {
+			for k, v := range conditional {
+				r.Header[k] = v
+			}
+		}
+
+		resp, err := doRequest(r)
+		if err != nil {
+			return 0, fmt.Errorf("stream GET: %w", err)
+		}
+		defer resp.Body.Close()
+
+		flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		switch {
+		case resp.StatusCode == http.StatusPartialContent && offset > 0:
+			if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+				// 返回的范围不对，丢弃已写入的部分
+				os.Remove(d.streamInfoFile())
+				return 0, fmt.Errorf("unexpected content range: %s", resp.Header.Get("Content-Range"))
+			}
+		case resp.StatusCode == http.StatusOK:
+			// 从头下载，记录这次响应的版本
+			flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+			offset = 0
+			info = streamInfo{
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+				Size:         resp.ContentLength,
+			}
+			data, err := json.Marshal(info)
+			if err != nil {
+				return resp.StatusCode, fmt.Errorf("encode stream info: %w", err)
+			}
+			if err := os.WriteFile(d.streamInfoFile(), data, 0644); err != nil {
+				return resp.StatusCode, fmt.Errorf("write stream info: %w", err)
+			}
+		case resp.StatusCode == http.StatusNotModified, resp.StatusCode == http.StatusNotFound:
+			return resp.StatusCode, nil
+		default:
+			return resp.StatusCode, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		}
+
+		f, err := os.OpenFile(d.streamFile(), flags, 0644)
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("create part file: %w", err)
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			// 已写入的部分保留，重试时续传；返回 0 让 withRetry 视为网络错误
+			return 0, fmt.Errorf("write target: %w", err)
+		}
+		if err := verifySize(f, info.Size); err != nil {
+			if errors.Is(err, errSizeMismatch) {
+				// 连接中途断开导致的截断，重试时续传
+				return 0, err
+			}
+			return resp.StatusCode, err
+		}
+		if err := f.Close(); err != nil {
+			return resp.StatusCode, fmt.Errorf("close target: %w", err)
+		}
+		if err := os.Rename(d.streamFile(), d.Target); err != nil {
+			if err := CopyFile(d.streamFile(), d.Target); err != nil {
+				return resp.StatusCode, fmt.Errorf("move target: %w", err)
+			}
+			os.Remove(d.streamFile())
+		}
+		os.Remove(d.streamInfoFile())
+
+		stat, err := os.Stat(d.Target)
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("stat target: %w", err)
+		}
+		result = http.Header{}
+		if info.ETag != "" {
+			result.Set("ETag", info.ETag)
+		}
+		if info.LastModified != "" {
+			result.Set("Last-Modified", info.LastModified)
+		}
+		result.Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+		return http.StatusOK, nil
+	})
+	return status, result, err
 }